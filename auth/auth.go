@@ -0,0 +1,200 @@
+// -------
+// auth.go ::: basic, bearer token and api key authentication
+// -------
+// Copyright (c) 2013-Present, Scott Cagno. All rights reserved.
+// This source code is governed by a BSD-style license.
+
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+type principalKey struct{}
+
+// verify a username and password, returning the principal on success
+type BasicVerifier func(user, pass string) (interface{}, bool)
+
+// verify a bearer token or api key, returning the principal on success
+type TokenVerifier func(token string) (interface{}, bool)
+
+// authentication scheme
+type Scheme interface {
+	// return the principal and true, or false if the request carries no
+	// valid credentials for this scheme
+	Authenticate(r *http.Request) (interface{}, bool)
+	// add a challenge to an unauthorized response
+	Challenge(w http.ResponseWriter)
+}
+
+// authentication middleware, tries each scheme in order
+type Authenticator struct {
+	Schemes []Scheme
+	Failure http.Handler
+}
+
+// return a new authenticator for the given schemes
+func NewAuthenticator(schemes ...Scheme) *Authenticator {
+	return &Authenticator{
+		Schemes: schemes,
+		Failure: http.HandlerFunc(unauthorized),
+	}
+}
+
+// wrap a handler, rejecting requests no scheme authenticates
+func (self *Authenticator) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, s := range self.Schemes {
+			if p, ok := s.Authenticate(r); ok {
+				h.ServeHTTP(w, WithPrincipal(r, p))
+				return
+			}
+		}
+		for _, s := range self.Schemes {
+			s.Challenge(w)
+		}
+		self.Failure.ServeHTTP(w, r)
+	})
+}
+
+// return a copy of the request carrying the principal
+func WithPrincipal(r *http.Request, p interface{}) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), principalKey{}, p))
+}
+
+// return the authenticated principal, or nil
+func Principal(r *http.Request) interface{} {
+	return r.Context().Value(principalKey{})
+}
+
+// default failure handler
+func unauthorized(w http.ResponseWriter, r *http.Request) {
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+}
+
+// http basic authentication
+type Basic struct {
+	Realm  string
+	Verify BasicVerifier
+}
+
+// return a new basic scheme
+func NewBasic(realm string, verify BasicVerifier) *Basic {
+	return &Basic{realm, verify}
+}
+
+// implement Scheme
+func (self *Basic) Authenticate(r *http.Request) (interface{}, bool) {
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return nil, false
+	}
+	return self.Verify(user, pass)
+}
+
+// implement Scheme
+func (self *Basic) Challenge(w http.ResponseWriter) {
+	w.Header().Add("WWW-Authenticate", `Basic realm="`+quoteRealm(self.Realm)+`", charset="UTF-8"`)
+}
+
+// bearer token authentication
+type Bearer struct {
+	Realm  string
+	Verify TokenVerifier
+}
+
+// return a new bearer scheme
+func NewBearer(realm string, verify TokenVerifier) *Bearer {
+	return &Bearer{realm, verify}
+}
+
+// implement Scheme
+func (self *Bearer) Authenticate(r *http.Request) (interface{}, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(token) == "" {
+		return nil, false
+	}
+	return self.Verify(strings.TrimSpace(token))
+}
+
+// implement Scheme
+func (self *Bearer) Challenge(w http.ResponseWriter) {
+	w.Header().Add("WWW-Authenticate", `Bearer realm="`+quoteRealm(self.Realm)+`"`)
+}
+
+// api key authentication from a header or query parameter
+type APIKey struct {
+	Header string
+	Param  string
+	Verify TokenVerifier
+}
+
+// return a new api key scheme, either header or param may be empty
+func NewAPIKey(header, param string, verify TokenVerifier) *APIKey {
+	return &APIKey{header, param, verify}
+}
+
+// implement Scheme
+func (self *APIKey) Authenticate(r *http.Request) (interface{}, bool) {
+	var key string
+	if self.Header != "" {
+		key = r.Header.Get(self.Header)
+	}
+	if key == "" && self.Param != "" {
+		key = r.URL.Query().Get(self.Param)
+	}
+	if key == "" {
+		return nil, false
+	}
+	return self.Verify(key)
+}
+
+// implement Scheme, api keys have no standard challenge
+func (self *APIKey) Challenge(w http.ResponseWriter) {}
+
+// compare two secrets in constant time, regardless of their lengths
+func Equal(a, b string) bool {
+	ha, hb := sha256.Sum256([]byte(a)), sha256.Sum256([]byte(b))
+	return subtle.ConstantTimeCompare(ha[:], hb[:]) == 1
+}
+
+// verifier for a fixed set of users and passwords, the principal is the
+// username
+func StaticUsers(users map[string]string) BasicVerifier {
+	return func(user, pass string) (interface{}, bool) {
+		want, ok := users[user]
+		if !ok {
+			// compare anyway so unknown users take as long as known ones
+			Equal(pass, pass)
+			return nil, false
+		}
+		if !Equal(pass, want) {
+			return nil, false
+		}
+		return user, true
+	}
+}
+
+// verifier for a fixed set of tokens mapped to principals, every token is
+// compared so timing does not reveal which one matched
+func StaticTokens(tokens map[string]interface{}) TokenVerifier {
+	return func(token string) (interface{}, bool) {
+		var found interface{}
+		ok := false
+		for t, p := range tokens {
+			if Equal(token, t) {
+				found, ok = p, true
+			}
+		}
+		return found, ok
+	}
+}
+
+// escape a realm for a quoted header parameter
+func quoteRealm(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s)
+}
@@ -0,0 +1,298 @@
+// --------
+// login.go ::: session based login
+// --------
+// Copyright (c) 2013-Present, Scott Cagno. All rights reserved.
+// This source code is governed by a BSD-style license.
+
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/scottcagno/net_kit/data"
+	"github.com/scottcagno/net_kit/sess"
+)
+
+const (
+	// session key holding the logged in user id
+	USER_KEY = "auth.user"
+	// default remember me cookie name
+	REMEMBER_COOKIE = "remember"
+)
+
+var (
+	ErrBadLogin = errors.New("auth: invalid username or password")
+	ErrLocked   = errors.New("auth: account locked, try again later")
+)
+
+// user account loaded by SessionAuth
+type User interface {
+	UserId() string
+	PasswordHash() string
+}
+
+// session based login on top of sess.Store, with users read from a data
+// wrapper. set Tokens to enable remember me
+type SessionAuth struct {
+	Store          *sess.Store
+	Users          data.DataWrapper
+	Tokens         data.DataWrapper
+	New            func() User
+	ByName         func(username string) interface{}
+	ById           func(id string) interface{}
+	Lockout        *Lockout
+	LoginPath      string
+	RedirectTo     string
+	RememberCookie string
+	RememberFor    time.Duration
+	RememberGrace  time.Duration
+	Failure        func(w http.ResponseWriter, r *http.Request, err error)
+	dummy          string
+}
+
+// return a new session auth, newUser returns a pointer to an empty user for
+// the wrapper to decode into
+func NewSessionAuth(store *sess.Store, users data.DataWrapper, newUser func() User) *SessionAuth {
+	dummy, _ := HashPassword(sess.Random(16))
+	return &SessionAuth{
+		Store: store,
+		Users: users,
+		New:   newUser,
+		ByName: func(username string) interface{} {
+			return map[string]interface{}{"username": username}
+		},
+		ById: func(id string) interface{} {
+			return map[string]interface{}{"_id": data.Id(id)}
+		},
+		Lockout:        NewLockout(5, 15*time.Minute),
+		LoginPath:      "/login",
+		RedirectTo:     "/",
+		RememberCookie: REMEMBER_COOKIE,
+		RememberFor:    30 * 24 * time.Hour,
+		RememberGrace:  30 * time.Second,
+		dummy:          dummy,
+	}
+}
+
+// log in from the username and password form values. on success the
+// session id is regenerated and the user redirected to the next form value
+// or RedirectTo, a true remember value also issues a remember me token
+func (self *SessionAuth) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	username, password := r.PostFormValue("username"), r.PostFormValue("password")
+	user, err := self.authenticate(username, password)
+	if err != nil {
+		self.fail(w, r, err)
+		return
+	}
+	session := self.Store.Regenerate(w, r)
+	session.SetValue(USER_KEY, user.UserId())
+	if self.Tokens != nil && isTrue(r.PostFormValue("remember")) {
+		self.issueToken(w, user.UserId())
+	}
+	next := r.PostFormValue("next")
+	if !localPath(next) {
+		next = self.RedirectTo
+	}
+	http.Redirect(w, r, next, 303)
+}
+
+// log out, ending the session and revoking the remember me token
+func (self *SessionAuth) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	if self.Tokens != nil {
+		self.clearToken(w, r)
+	}
+	self.Store.DelSession(w, r)
+	http.Redirect(w, r, self.LoginPath, 303)
+}
+
+// check credentials, counting failures towards a lockout
+func (self *SessionAuth) authenticate(username, password string) (User, error) {
+	if self.Lockout != nil && self.Lockout.Locked(username) {
+		return nil, ErrLocked
+	}
+	user := self.New()
+	if err, ok := self.Users.Return(self.ByName(username), user, 1).(error); ok && err != nil {
+		// hash anyway so unknown users take as long as known ones
+		CheckPassword(self.dummy, password)
+		if self.failed(username) {
+			return nil, ErrLocked
+		}
+		return nil, ErrBadLogin
+	}
+	if !CheckPassword(user.PasswordHash(), password) {
+		if self.failed(username) {
+			return nil, ErrLocked
+		}
+		return nil, ErrBadLogin
+	}
+	if self.Lockout != nil {
+		self.Lockout.Reset(username)
+	}
+	return user, nil
+}
+
+// count a failed login, reporting whether the account is now locked
+func (self *SessionAuth) failed(username string) bool {
+	return self.Lockout != nil && self.Lockout.Fail(username)
+}
+
+// answer a failed login, by default flashing the error and going back to
+// the login page
+func (self *SessionAuth) fail(w http.ResponseWriter, r *http.Request, err error) {
+	if self.Failure != nil {
+		self.Failure(w, r, err)
+		return
+	}
+	self.Store.GetSession(w, r).SetFlash("error", "login", err.Error())
+	path := self.LoginPath
+	if next := r.PostFormValue("next"); localPath(next) {
+		path += "?next=" + url.QueryEscape(next)
+	}
+	http.Redirect(w, r, path, 303)
+}
+
+// middleware loading the current user from the session, or from a remember
+// me cookie, into the request. see CurrentUser
+func (self *SessionAuth) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, r := self.load(w, r)
+		if user != nil {
+			r = WithPrincipal(r, user)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// middleware letting only logged in users through, others are sent to the
+// login page or, for requests other than get, refused
+func (self *SessionAuth) RequireLogin(h http.Handler) http.Handler {
+	return self.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if CurrentUser(r) != nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if r.Method != "GET" && r.Method != "HEAD" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		http.Redirect(w, r, self.LoginPath+"?next="+url.QueryEscape(r.URL.RequestURI()), 303)
+	}))
+}
+
+// load the logged in user, returning the request with its session attached
+// so a session regenerated here is the one later handlers get
+func (self *SessionAuth) load(w http.ResponseWriter, r *http.Request) (User, *http.Request) {
+	session := self.Store.GetSession(w, r)
+	r = self.Store.WithSession(r, session)
+	id := session.GetString(USER_KEY)
+	if id == "" && self.Tokens != nil {
+		var ok bool
+		if id, ok = self.useToken(w, r); !ok {
+			return nil, r
+		}
+		session = self.Store.Regenerate(w, r)
+		session.SetValue(USER_KEY, id)
+	}
+	if id == "" {
+		return nil, r
+	}
+	user := self.New()
+	if err, ok := self.Users.Return(self.ById(id), user, 1).(error); ok && err != nil {
+		// the account is gone
+		session.Del(USER_KEY)
+		return nil, r
+	}
+	return user, r
+}
+
+// return the user loaded by SessionAuth.Handler, or nil
+func CurrentUser(r *http.Request) User {
+	user, _ := Principal(r).(User)
+	return user
+}
+
+// failed login counter locking a username after Max failures for Duration
+type Lockout struct {
+	Max      int
+	Duration time.Duration
+	entries  map[string]*lockEntry
+	mu       sync.Mutex
+}
+
+type lockEntry struct {
+	failures int
+	last     time.Time
+	until    time.Time
+}
+
+// return a new lockout
+func NewLockout(max int, d time.Duration) *Lockout {
+	return &Lockout{Max: max, Duration: d, entries: make(map[string]*lockEntry)}
+}
+
+// report whether a username is locked
+func (self *Lockout) Locked(name string) bool {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	e, ok := self.entries[name]
+	return ok && e.until.After(time.Now())
+}
+
+// count a failure, reporting whether the username is now locked. failures
+// older than Duration are forgotten
+func (self *Lockout) Fail(name string) bool {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	now := time.Now()
+	if len(self.entries) > 10000 {
+		self.prune(now)
+	}
+	e, ok := self.entries[name]
+	if !ok || now.Sub(e.last) > self.Duration {
+		e = &lockEntry{}
+		self.entries[name] = e
+	}
+	e.failures++
+	e.last = now
+	if e.failures >= self.Max {
+		e.failures = 0
+		e.until = now.Add(self.Duration)
+		return true
+	}
+	return false
+}
+
+// forget a username's failures
+func (self *Lockout) Reset(name string) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	delete(self.entries, name)
+}
+
+// drop entries neither locked nor recently failed
+func (self *Lockout) prune(now time.Time) {
+	for name, e := range self.entries {
+		if e.until.Before(now) && now.Sub(e.last) > self.Duration {
+			delete(self.entries, name)
+		}
+	}
+}
+
+// parse a checkbox style form value
+func isTrue(s string) bool {
+	switch strings.ToLower(s) {
+	case "1", "on", "true", "yes":
+		return true
+	}
+	return false
+}
+
+// report whether s is a path on this site, safe to redirect to
+func localPath(s string) bool {
+	return strings.HasPrefix(s, "/") && !strings.HasPrefix(s, "//") && !strings.HasPrefix(s, "/\\")
+}
@@ -0,0 +1,261 @@
+// -------------
+// login_test.go ::: session login and remember me tests
+// -------------
+// Copyright (c) 2013-Present, Scott Cagno. All rights reserved.
+// This source code is governed by a BSD-style license.
+
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/scottcagno/net_kit/sess"
+)
+
+// in memory data wrapper holding json documents, id names the json field
+// standing in for "_id"
+type memData struct {
+	id   string
+	docs []map[string]interface{}
+	mu   sync.Mutex
+}
+
+// round trip v through json so documents and selectors compare alike
+func jsonDoc(v interface{}) map[string]interface{} {
+	b, _ := json.Marshal(v)
+	var doc map[string]interface{}
+	json.Unmarshal(b, &doc)
+	return doc
+}
+
+func (self *memData) match(doc map[string]interface{}, sel interface{}) bool {
+	for k, v := range jsonDoc(sel) {
+		if k == "_id" {
+			k = self.id
+		}
+		if fmt.Sprint(doc[k]) != fmt.Sprint(v) {
+			return false
+		}
+	}
+	return true
+}
+
+func (self *memData) Insert(v ...interface{}) interface{} {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	for _, doc := range v {
+		self.docs = append(self.docs, jsonDoc(doc))
+	}
+	return len(v)
+}
+
+func (self *memData) Update(v ...interface{}) interface{} {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	set := jsonDoc(v[1])["$set"].(map[string]interface{})
+	n := 0
+	for _, doc := range self.docs {
+		if self.match(doc, v[0]) {
+			for k, x := range set {
+				doc[k] = x
+			}
+			n++
+		}
+	}
+	return n
+}
+
+func (self *memData) Return(v ...interface{}) interface{} {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	for _, doc := range self.docs {
+		if self.match(doc, v[0]) {
+			b, _ := json.Marshal(doc)
+			return json.Unmarshal(b, v[1])
+		}
+	}
+	return errors.New("not found")
+}
+
+func (self *memData) Delete(v ...interface{}) interface{} {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	kept := self.docs[:0]
+	for _, doc := range self.docs {
+		if !self.match(doc, v[0]) {
+			kept = append(kept, doc)
+		}
+	}
+	n := len(self.docs) - len(kept)
+	self.docs = kept
+	return n
+}
+
+// number of stored documents
+func (self *memData) Len() int {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	return len(self.docs)
+}
+
+type testUser struct {
+	Id       string `json:"_id"`
+	Username string `json:"username"`
+	Hash     string `json:"hash"`
+}
+
+func (self *testUser) UserId() string       { return self.Id }
+func (self *testUser) PasswordHash() string { return self.Hash }
+
+// session auth with a single user alice, serving /login and /me
+func newLogin(t *testing.T) (*SessionAuth, *memData, http.Handler) {
+	Iterations = 1000
+	hash, err := HashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := &memData{id: "_id"}
+	users.Insert(testUser{"u1", "alice", hash})
+	tokens := &memData{id: "selector"}
+	store := sess.NewSessionStore("sid", sess.HOUR)
+	t.Cleanup(store.Stop)
+	a := NewSessionAuth(store, users, func() User { return &testUser{} })
+	a.Tokens = tokens
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", a.LoginHandler)
+	mux.Handle("/me", a.RequireLogin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, CurrentUser(r).UserId(), " ", store.GetSession(w, r).GetString(USER_KEY))
+	})))
+	return a, tokens, mux
+}
+
+// serve a request with the given cookies, returning the response
+func serve(h http.Handler, r *http.Request, cookies ...*http.Cookie) *http.Response {
+	for _, c := range cookies {
+		r.AddCookie(c)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w.Result()
+}
+
+// cookies of a response with the given name
+func cookies(res *http.Response, name string) []*http.Cookie {
+	var found []*http.Cookie
+	for _, c := range res.Cookies() {
+		if c.Name == name {
+			found = append(found, c)
+		}
+	}
+	return found
+}
+
+// log alice in with remember me, returning the remember cookie
+func remembered(t *testing.T, h http.Handler) *http.Cookie {
+	r := httptest.NewRequest("POST", "/login", strings.NewReader("username=alice&password=secret&remember=on"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res := serve(h, r)
+	if res.StatusCode != 303 || res.Header.Get("Location") != "/" {
+		t.Fatalf("login failed: %d %s", res.StatusCode, res.Header.Get("Location"))
+	}
+	found := cookies(res, REMEMBER_COOKIE)
+	if len(found) != 1 {
+		t.Fatal("no remember cookie issued")
+	}
+	return found[0]
+}
+
+// get /me, reporting the body or "" when sent to log in
+func me(h http.Handler, cookies ...*http.Cookie) (string, *http.Response) {
+	res := serve(h, httptest.NewRequest("GET", "/me", nil), cookies...)
+	if res.StatusCode != 200 {
+		return "", res
+	}
+	body, _ := io.ReadAll(res.Body)
+	return string(body), res
+}
+
+// a remember me login resolves to a single regenerated session, and that
+// session stays logged in
+func TestRememberLogin(t *testing.T) {
+	_, _, h := newLogin(t)
+	token := remembered(t, h)
+	body, res := me(h, token)
+	if body != "u1 u1" {
+		t.Fatalf("expected logged in session, got %q", body)
+	}
+	sids := cookies(res, "sid")
+	if len(sids) != 1 {
+		t.Fatalf("expected one session cookie, got %d", len(sids))
+	}
+	if body, _ := me(h, sids[0]); body != "u1 u1" {
+		t.Fatalf("session cookie is not logged in, got %q", body)
+	}
+}
+
+// the previous token works within the grace window without rotating
+// again, later it revokes every token of the user
+func TestRememberRotation(t *testing.T) {
+	a, tokens, h := newLogin(t)
+	a.RememberGrace = 50 * time.Millisecond
+	old := remembered(t, h)
+	body, res := me(h, old)
+	if body != "u1 u1" {
+		t.Fatalf("remember login failed, got %q", body)
+	}
+	rotated := cookies(res, REMEMBER_COOKIE)
+	if len(rotated) != 1 || strings.Split(rotated[0].Value, ":")[0] == strings.Split(old.Value, ":")[0] {
+		t.Fatal("token was not rotated to a new selector")
+	}
+	body, res = me(h, old)
+	if body != "u1 u1" || len(cookies(res, REMEMBER_COOKIE)) != 0 {
+		t.Fatalf("previous token refused or rotated again within grace, got %q", body)
+	}
+	time.Sleep(2 * a.RememberGrace)
+	if body, _ := me(h, old); body != "" {
+		t.Fatal("previous token accepted after grace")
+	}
+	if tokens.Len() != 0 {
+		t.Fatalf("reuse left %d tokens", tokens.Len())
+	}
+	if body, _ := me(h, rotated[0]); body != "" {
+		t.Fatal("rotated token survived reuse")
+	}
+}
+
+// a wrong validator for a known selector revokes every token of the user
+func TestRememberForged(t *testing.T) {
+	_, tokens, h := newLogin(t)
+	token := remembered(t, h)
+	selector, _, _ := strings.Cut(token.Value, ":")
+	forged := &http.Cookie{Name: REMEMBER_COOKIE, Value: selector + ":guess"}
+	if body, _ := me(h, forged); body != "" {
+		t.Fatal("forged token accepted")
+	}
+	if tokens.Len() != 0 {
+		t.Fatal("forged token did not revoke")
+	}
+}
+
+// unknown and known usernames lock out alike
+func TestLockoutUnknownUser(t *testing.T) {
+	a, _, _ := newLogin(t)
+	for _, name := range []string{"alice", "nobody"} {
+		var err error
+		for i := 0; i < a.Lockout.Max; i++ {
+			_, err = a.authenticate(name, "wrong")
+		}
+		if err != ErrLocked {
+			t.Fatalf("%s: expected ErrLocked, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+// -----------
+// password.go ::: password hashing
+// -----------
+// Copyright (c) 2013-Present, Scott Cagno. All rights reserved.
+// This source code is governed by a BSD-style license.
+
+package auth
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// hash identifier stored with each password hash
+const PBKDF2_SHA256 = "pbkdf2-sha256"
+
+// pbkdf2 rounds for new hashes, existing hashes keep the count they were
+// made with
+var Iterations = 600000
+
+// hash a password with a random salt, the result holds everything needed
+// to check it, ie. "pbkdf2-sha256$600000$<salt>$<key>"
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, Iterations, 32)
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawStdEncoding
+	return fmt.Sprintf("%s$%d$%s$%s", PBKDF2_SHA256, Iterations, enc.EncodeToString(salt), enc.EncodeToString(key)), nil
+}
+
+// check a password against a hash made by HashPassword
+func CheckPassword(hash, password string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != PBKDF2_SHA256 {
+		return false
+	}
+	iter, err := strconv.Atoi(parts[1])
+	if err != nil || iter < 1 {
+		return false
+	}
+	enc := base64.RawStdEncoding
+	salt, err := enc.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	want, err := enc.DecodeString(parts[3])
+	if err != nil || len(want) == 0 {
+		return false
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, iter, len(want))
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(key, want) == 1
+}
+
+// report whether a hash was made with fewer rounds than Iterations
+func NeedsRehash(hash string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != PBKDF2_SHA256 {
+		return true
+	}
+	iter, err := strconv.Atoi(parts[1])
+	return err != nil || iter < Iterations
+}
@@ -0,0 +1,126 @@
+// -----------
+// remember.go ::: persistent remember me tokens
+// -----------
+// Copyright (c) 2013-Present, Scott Cagno. All rights reserved.
+// This source code is governed by a BSD-style license.
+
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/scottcagno/net_kit/sess"
+)
+
+// stored remember me token, only a hash of the validator is kept so a
+// leaked token collection cannot be used to log in. a rotated token stays
+// Replaced until its short grace expiry
+type rememberToken struct {
+	Selector string    `json:"selector" bson:"_id"`
+	Hash     string    `json:"hash" bson:"hash"`
+	User     string    `json:"user" bson:"user"`
+	Expires  time.Time `json:"expires" bson:"expires"`
+	Replaced bool      `json:"replaced" bson:"replaced"`
+}
+
+// issue a new token for the user and set its cookie
+func (self *SessionAuth) issueToken(w http.ResponseWriter, user string) {
+	validator := sess.Random(32)
+	tok := rememberToken{
+		Selector: sess.Random(12),
+		Hash:     hashToken(validator),
+		User:     user,
+		Expires:  time.Now().Add(self.RememberFor),
+	}
+	if err, ok := self.Tokens.Insert(tok).(error); ok {
+		log.Printf("auth: remember: %v\n", err)
+		return
+	}
+	cookie := self.rememberCookie(tok.Selector + ":" + validator)
+	cookie.MaxAge = int(self.RememberFor.Seconds())
+	cookie.Expires = tok.Expires
+	http.SetCookie(w, &cookie)
+}
+
+// log in from a remember me cookie, rotating the token to a new one. the
+// previous token keeps working for RememberGrace so concurrent requests
+// carrying it get through, using it later means it was copied and every
+// token of that user is revoked, as is a known selector with a wrong
+// validator
+func (self *SessionAuth) useToken(w http.ResponseWriter, r *http.Request) (string, bool) {
+	cookie, err := r.Cookie(self.RememberCookie)
+	if err != nil {
+		return "", false
+	}
+	selector, validator, ok := strings.Cut(cookie.Value, ":")
+	if !ok {
+		self.clearToken(w, r)
+		return "", false
+	}
+	var tok rememberToken
+	if err, ok := self.Tokens.Return(map[string]interface{}{"_id": selector}, &tok, 1).(error); ok && err != nil {
+		self.clearToken(w, r)
+		return "", false
+	}
+	now := time.Now()
+	if !Equal(hashToken(validator), tok.Hash) || tok.Replaced && tok.Expires.Before(now) {
+		log.Printf("auth: remember token reuse for user %s, revoking all\n", tok.User)
+		self.Tokens.Delete(map[string]interface{}{"user": tok.User})
+		self.clearToken(w, r)
+		return "", false
+	}
+	if tok.Replaced {
+		// a concurrent request rotated it, its response carries the new one
+		return tok.User, true
+	}
+	if tok.Expires.Before(now) {
+		self.clearToken(w, r)
+		return "", false
+	}
+	n, _ := self.Tokens.Update(
+		map[string]interface{}{"_id": selector, "replaced": false},
+		map[string]interface{}{"$set": map[string]interface{}{"replaced": true, "expires": now.Add(self.RememberGrace)}},
+	).(int)
+	if n == 1 {
+		self.issueToken(w, tok.User)
+	}
+	return tok.User, true
+}
+
+// revoke the request's token and expire its cookie
+func (self *SessionAuth) clearToken(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(self.RememberCookie); err == nil {
+		if selector, _, ok := strings.Cut(cookie.Value, ":"); ok {
+			self.Tokens.Delete(map[string]interface{}{"_id": selector})
+		}
+	}
+	cookie := self.rememberCookie("")
+	cookie.MaxAge = -1
+	cookie.Expires = time.Unix(0, 0)
+	http.SetCookie(w, &cookie)
+}
+
+// remember cookie sharing the session cookie's attributes
+func (self *SessionAuth) rememberCookie(value string) http.Cookie {
+	opts := self.Store.Cookie
+	return http.Cookie{
+		Name:     self.RememberCookie,
+		Value:    value,
+		Path:     opts.Path,
+		Domain:   opts.Domain,
+		Secure:   opts.Secure,
+		HttpOnly: true,
+		SameSite: opts.SameSite,
+	}
+}
+
+// hex sha-256 of a token validator
+func hashToken(validator string) string {
+	sum := sha256.Sum256([]byte(validator))
+	return hex.EncodeToString(sum[:])
+}
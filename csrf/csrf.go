@@ -0,0 +1,198 @@
+// -------
+// csrf.go ::: cross-site request forgery protection
+// -------
+// Copyright (c) 2013-Present, Scott Cagno. All rights reserved.
+// This source code is governed by a BSD-style license.
+
+package csrf
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"html/template"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+
+	"github.com/scottcagno/net_kit/frms"
+	"github.com/scottcagno/net_kit/sess"
+)
+
+const (
+	FIELD  = "csrf_token"
+	HEADER = "X-CSRF-Token"
+	KEY    = "csrf"
+)
+
+// most bytes of a multipart body read looking for the token field
+const peekLen = 64 << 10
+
+var (
+	ErrNoToken  = errors.New("csrf: token missing from request")
+	ErrBadToken = errors.New("csrf: token does not match session")
+)
+
+type ctxKey int
+
+const (
+	stateKey ctxKey = iota
+	reasonKey
+)
+
+// per request token state
+type state struct {
+	token, field string
+}
+
+// csrf guard
+type Guard struct {
+	Field   string
+	Header  string
+	Failure http.Handler
+	store   *sess.Store
+}
+
+// return a new csrf guard backed by a session store
+func NewGuard(store *sess.Store) *Guard {
+	return &Guard{
+		Field:   FIELD,
+		Header:  HEADER,
+		Failure: http.HandlerFunc(forbidden),
+		store:   store,
+	}
+}
+
+// wrap a handler, validating the token on unsafe methods. the token is
+// taken from the header or the form field, multipart bodies are read only
+// up to the field so uploads stay streamed
+func (self *Guard) Protect(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session := self.store.GetSession(w, r)
+		if session == nil {
+			session = self.store.NewSession(w, r)
+		}
+		var token string
+		if vals := session.Get(KEY); len(vals) > 0 {
+			token = vals[0]
+		}
+		if token == "" {
+			token = sess.Random(32)
+			session.Set(KEY, []string{token})
+		}
+		r = r.WithContext(context.WithValue(r.Context(), stateKey, &state{token, self.Field}))
+		if !isSafe(r.Method) {
+			if err := self.check(r, token); err != nil {
+				r = r.WithContext(context.WithValue(r.Context(), reasonKey, err))
+				self.Failure.ServeHTTP(w, r)
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// compare the submitted token against the session token
+func (self *Guard) check(r *http.Request, token string) error {
+	sent := r.Header.Get(self.Header)
+	if ct, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); sent == "" && ct == "multipart/form-data" {
+		sent = self.peekToken(r, params["boundary"])
+	} else if sent == "" {
+		sent = r.PostFormValue(self.Field)
+	}
+	if sent == "" {
+		return ErrNoToken
+	}
+	if subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+		return ErrBadToken
+	}
+	return nil
+}
+
+// find the token field of a multipart body without parsing the files, so
+// uploads can still be streamed by the handler. the field has to come
+// before any file, the bytes read are put back in front of the body
+func (self *Guard) peekToken(r *http.Request, boundary string) string {
+	if boundary == "" || r.Body == nil {
+		return ""
+	}
+	var buf bytes.Buffer
+	body := r.Body
+	defer func() {
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(&buf, body), body}
+	}()
+	mr := multipart.NewReader(io.TeeReader(io.LimitReader(body, peekLen), &buf), boundary)
+	for {
+		part, err := mr.NextPart()
+		if err != nil || part.FileName() != "" {
+			return ""
+		}
+		if part.FormName() == self.Field {
+			b, _ := io.ReadAll(io.LimitReader(part, 256))
+			return string(b)
+		}
+	}
+}
+
+// return the csrf token for the current request
+func Token(r *http.Request) string {
+	if st, ok := r.Context().Value(stateKey).(*state); ok {
+		return st.token
+	}
+	return ""
+}
+
+// return the reason a request failed validation
+func Reason(r *http.Request) error {
+	err, _ := r.Context().Value(reasonKey).(error)
+	return err
+}
+
+// template function rendering the hidden token input
+func Field(r *http.Request) interface{} {
+	st, ok := r.Context().Value(stateKey).(*state)
+	if !ok {
+		return template.HTML("")
+	}
+	return template.HTML(`<input type="hidden" name="` + template.HTMLEscapeString(st.field) +
+		`" value="` + template.HTMLEscapeString(st.token) + `">`)
+}
+
+// template function returning the raw token, for meta tags and ajax
+func TokenFunc(r *http.Request) interface{} {
+	return Token(r)
+}
+
+// default failure handler
+func forbidden(w http.ResponseWriter, r *http.Request) {
+	msg := "Forbidden - invalid csrf token"
+	if err := Reason(r); err != nil {
+		msg = "Forbidden - " + err.Error()
+	}
+	http.Error(w, msg, http.StatusForbidden)
+}
+
+// test for methods that do not modify state
+func isSafe(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE":
+		return true
+	}
+	return false
+}
+
+// inject the token into frms.Form renders
+func init() {
+	frms.RegisterHidden(func(r *http.Request) (frms.Input, bool) {
+		st, ok := r.Context().Value(stateKey).(*state)
+		if !ok {
+			return frms.Input{}, false
+		}
+		return frms.Input{Name: st.field, Type: "hidden", Value: st.token}, true
+	})
+}
@@ -7,15 +7,25 @@
 package data
 
 import (
+	"context"
+	"log"
+	"reflect"
+	"strings"
+
+	"github.com/scottcagno/net_kit/trace"
 	"labix.org/v2/mgo"
 	"labix.org/v2/mgo/bson"
 )
 
+// returned by Return when a single document lookup finds nothing
+var ErrNotFound = mgo.ErrNotFound
+
 // mgo data wrapper
 type MgoWrapper struct {
 	Session  *mgo.Session
 	Database *mgo.Database
 	C        *mgo.Collection
+	ctx      context.Context
 }
 
 // return a new data wrapper instance
@@ -41,24 +51,111 @@ func (self *MgoWrapper) SetC(c string) *MgoWrapper {
 	return self
 }
 
+// return a copy bound to a request context, used for trace logging
+func (self *MgoWrapper) Context(ctx context.Context) *MgoWrapper {
+	wrapper := *self
+	wrapper.ctx = ctx
+	return &wrapper
+}
+
+// log a failed operation with the request trace prefix
+func (self *MgoWrapper) logErr(op string, err error) {
+	log.Printf("%smgo %s: %v\n", trace.Prefix(self.ctx), op, err)
+}
+
 // insert
 func (self *MgoWrapper) Insert(v ...interface{}) interface{} {
 	err := self.C.Insert(v...)
 	if err != nil {
+		self.logErr("insert", err)
 		return err
 	}
 	return len(v)
 }
 
-// update
+// update, a replacement document without $ operators replaces the first
+// match only since mongo won't apply one to several documents
 func (self *MgoWrapper) Update(v ...interface{}) interface{} {
+	if !operators(v[1]) {
+		err := self.C.Update(v[0], v[1])
+		if err == ErrNotFound {
+			return 0
+		}
+		if err != nil {
+			self.logErr("update", err)
+			return err
+		}
+		return 1
+	}
 	info, err := self.C.UpdateAll(v[0], v[1])
 	if err != nil {
+		self.logErr("update", err)
 		return err
 	}
 	return info.Updated
 }
 
+// return the _id value for an id taken from a path or session, an object
+// id for 24 hex digits, otherwise the string itself
+func Id(id string) interface{} {
+	if bson.IsObjectIdHex(id) {
+		return bson.ObjectIdHex(id)
+	}
+	return id
+}
+
+// fill in an empty _id of a map or struct pointer with a new object id
+// before insert, so the caller holds the id the document is stored under
+func EnsureId(doc interface{}) {
+	switch doc := doc.(type) {
+	case bson.M:
+		if id, ok := doc["_id"]; !ok || id == nil || id == "" {
+			doc["_id"] = bson.NewObjectId()
+		}
+		return
+	case map[string]interface{}:
+		if id, ok := doc["_id"]; !ok || id == nil || id == "" {
+			doc["_id"] = bson.NewObjectId()
+		}
+		return
+	}
+	val := reflect.ValueOf(doc)
+	if val.Kind() != reflect.Ptr || val.Elem().Kind() != reflect.Struct {
+		return
+	}
+	val = val.Elem()
+	for i := 0; i < val.NumField(); i++ {
+		fld := val.Type().Field(i)
+		if strings.Split(fld.Tag.Get("bson"), ",")[0] != "_id" || !val.Field(i).CanSet() || !val.Field(i).IsZero() {
+			continue
+		}
+		switch id := bson.NewObjectId(); {
+		case fld.Type == reflect.TypeOf(id):
+			val.Field(i).Set(reflect.ValueOf(id))
+		case fld.Type.Kind() == reflect.String:
+			val.Field(i).SetString(id.Hex())
+		}
+		return
+	}
+}
+
+// report whether an update document holds $ operators
+func operators(doc interface{}) bool {
+	var m map[string]interface{}
+	switch doc := doc.(type) {
+	case bson.M:
+		m = doc
+	case map[string]interface{}:
+		m = doc
+	}
+	for k := range m {
+		if strings.HasPrefix(k, "$") {
+			return true
+		}
+	}
+	return false
+}
+
 // return
 func (self *MgoWrapper) Return(v ...interface{}) interface{} {
 	var lmt int
@@ -86,6 +183,9 @@ func (self *MgoWrapper) Return(v ...interface{}) interface{} {
 	default:
 		ret = self.C.Find(sel).Limit(lmt).All(set)
 	}
+	if err, ok := ret.(error); ok && err != ErrNotFound {
+		self.logErr("return", err)
+	}
 	return ret
 }
 
@@ -93,6 +193,7 @@ func (self *MgoWrapper) Return(v ...interface{}) interface{} {
 func (self *MgoWrapper) Delete(v ...interface{}) interface{} {
 	info, err := self.C.RemoveAll(v[0])
 	if err != nil {
+		self.logErr("delete", err)
 		return err
 	}
 	return info.Removed
@@ -0,0 +1,102 @@
+// ----------
+// session.go ::: mongo session backend
+// ----------
+// Copyright (c) 2013-Present, Scott Cagno. All rights reserved.
+// This source code is governed by a BSD-style license.
+
+package data
+
+import (
+	"time"
+
+	"github.com/scottcagno/net_kit/sess"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+)
+
+// stored session document
+type sessionDoc struct {
+	Id      string    `bson:"_id"`
+	Data    []byte    `bson:"data"`
+	Expires time.Time `bson:"expires"`
+}
+
+// session backend keeping sessions in the wrapper's collection
+type MgoSessions struct {
+	w *MgoWrapper
+}
+
+// return a new mongo session backend, the wrapper must have its database
+// and collection set. a ttl index lets mongo drop expired sessions itself
+func NewMgoSessions(w *MgoWrapper) *MgoSessions {
+	err := w.C.EnsureIndex(mgo.Index{Key: []string{"expires"}, ExpireAfter: time.Second})
+	if err != nil {
+		w.logErr("index", err)
+	}
+	return &MgoSessions{w}
+}
+
+// implement sess.SessionBackend
+func (self *MgoSessions) Load(sid string) ([]byte, error) {
+	var doc sessionDoc
+	err := self.w.C.FindId(sid).One(&doc)
+	if err == ErrNotFound || err == nil && doc.Expires.Before(time.Now()) {
+		return nil, sess.ErrNoSession
+	}
+	if err != nil {
+		self.w.logErr("session load", err)
+		return nil, err
+	}
+	return doc.Data, nil
+}
+
+// implement sess.SessionBackend
+func (self *MgoSessions) Save(sid string, data []byte, expires time.Time) error {
+	_, err := self.w.C.UpsertId(sid, sessionDoc{sid, data, expires})
+	if err != nil {
+		self.w.logErr("session save", err)
+	}
+	return err
+}
+
+// implement sess.SessionBackend
+func (self *MgoSessions) Touch(sid string, expires time.Time) error {
+	err := self.w.C.UpdateId(sid, bson.M{"$set": bson.M{"expires": expires}})
+	if err == ErrNotFound {
+		return sess.ErrNoSession
+	}
+	if err != nil {
+		self.w.logErr("session touch", err)
+	}
+	return err
+}
+
+// implement sess.SessionBackend
+func (self *MgoSessions) Delete(sid string) error {
+	err := self.w.C.RemoveId(sid)
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		self.w.logErr("session delete", err)
+	}
+	return err
+}
+
+// implement sess.SessionBackend
+func (self *MgoSessions) GC(now time.Time) error {
+	_, err := self.w.C.RemoveAll(bson.M{"expires": bson.M{"$lt": now}})
+	if err != nil {
+		self.w.logErr("session gc", err)
+	}
+	return err
+}
+
+// number of stored sessions
+func (self *MgoSessions) Len() int {
+	n, err := self.w.C.Count()
+	if err != nil {
+		self.w.logErr("session count", err)
+	}
+	return n
+}
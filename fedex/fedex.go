@@ -8,12 +8,16 @@ package fedex
 
 import (
 	"bytes"
+	"context"
 	"encoding/xml"
 	"fmt"
 	"html/template"
 	"io/ioutil"
+	"log"
 	"net/http"
 	"time"
+
+	"github.com/scottcagno/net_kit/trace"
 )
 
 var (
@@ -111,10 +115,22 @@ func (self *Shipment) ParsePackages(orderQty, maxCartonCnt, stockWeight int) {
 }
 
 func (self *Package) MakeRequest(autoParse bool) []byte {
+	return self.MakeRequestContext(context.Background(), autoParse)
+}
+
+// make request, propagating the request id and trace context from ctx
+func (self *Package) MakeRequestContext(ctx context.Context, autoParse bool) []byte {
 	var xml bytes.Buffer
 	self.Shipment.RequestTemplate.Execute(&xml, map[string]interface{}{"fedex": self.Shipment, "package": self})
-	response, err := http.Post(self.Shipment.Account.ApiURI, "application/xml", &xml)
+	request, err := http.NewRequestWithContext(ctx, "POST", self.Shipment.Account.ApiURI, &xml)
+	if err != nil {
+		panic(err)
+	}
+	request.Header.Set("Content-Type", "application/xml")
+	trace.Inject(ctx, request.Header)
+	response, err := http.DefaultClient.Do(request)
 	if err != nil {
+		log.Printf("%sfedex request for package %d: %v\n", trace.Prefix(ctx), self.SequenceNumber, err)
 		panic(err)
 	}
 	defer response.Body.Close()
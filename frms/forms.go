@@ -19,10 +19,14 @@ type MultiForm struct {
 	Forms                      map[string]*template.Template
 }
 
+// hidden form field carrying the method override for PUT, PATCH and DELETE
+const METHOD_FIELD = "_method"
+
 type Form struct {
-	Action, Button, ButtonName string
-	Inputs                     []Input
-	Errors                     map[string]string
+	Action, Button, ButtonName, Method string
+	Inputs                             []Input
+	Hidden                             []Input
+	Errors                             map[string]string
 }
 
 type Input struct {
@@ -37,6 +41,28 @@ func (self *Form) Render(t *template.Template) string {
 	return html.String()
 }
 
+// render the form for a request, injecting registered hidden inputs
+func (self *Form) RenderRequest(t *template.Template, r *http.Request) string {
+	form := *self
+	form.Hidden = append([]Input(nil), self.Hidden...)
+	for _, fn := range hiddenFuncs {
+		if input, ok := fn(r); ok {
+			form.Hidden = append(form.Hidden, input)
+		}
+	}
+	return form.Render(t)
+}
+
+// hidden input provider, called with the current request on render
+type HiddenFunc func(r *http.Request) (Input, bool)
+
+var hiddenFuncs []HiddenFunc
+
+// register a hidden input provider, should be called from init
+func RegisterHidden(fn HiddenFunc) {
+	hiddenFuncs = append(hiddenFuncs, fn)
+}
+
 func (self *Form) SetError(inputName, errStr string) {
 	for i := 0; i < len(self.Inputs); i++ {
 		if self.Inputs[i].Name == inputName {
@@ -122,6 +148,9 @@ func init() {
 		"safe": func(s string) template.HTMLAttr {
 			return template.HTMLAttr(s)
 		},
+		"methodField": func() string {
+			return METHOD_FIELD
+		},
 	}
 	DEFAULT = template.Must(template.New("form").Funcs(FUNCS).Parse(DEFAULT_FORM))
 	INLINE = template.Must(template.New("form").Funcs(FUNCS).Parse(INLINE_FORM))
@@ -130,6 +159,8 @@ func init() {
 
 var PARTIAL *template.Template
 var PARTIAL_FORM = `<fieldset>		
+    {{ if .Method }}<input type="hidden" name="{{ methodField }}" value="{{ .Method }}">{{ end }}
+    {{range .Hidden}}<input type="hidden" name="{{.Name}}" value="{{.Value}}">{{end}}
     {{range .Inputs}}
         <label class="lead-small text-error text-left">{{ .Error }}</label>
         <input class="input{{if .Class}} {{.Class}}{{end}}" type="{{.Type}}" name="{{.Name}}" {{ if .Value }}value="{{ .Value }}"{{ end }} placeholder="{{ .Holder }}" {{if .Min}}min="{{.Min}}"{{end}} {{if .Max}}max="{{.Max}}"{{end}} {{if .Required}}required{{end}}>
@@ -139,6 +170,8 @@ var PARTIAL_FORM = `<fieldset>
 var DEFAULT *template.Template
 var DEFAULT_FORM = `<form method="post" action="{{ .Action }}" class="text-center">
     <fieldset>		
+    {{ if .Method }}<input type="hidden" name="{{ methodField }}" value="{{ .Method }}">{{ end }}
+    {{range .Hidden}}<input type="hidden" name="{{.Name}}" value="{{.Value}}">{{end}}
     {{range .Inputs}}
         <label class="lead-small text-error text-left">{{ .Error }}</label>
         <input class="input{{if .Class}} {{.Class}}{{end}}" type="{{.Type}}" name="{{.Name}}" {{ if .Value }}value="{{ .Value }}"{{ end }} placeholder="{{ .Holder }}" {{if .Min}}min="{{.Min}}"{{end}} {{if .Max}}max="{{.Max}}"{{end}} {{if .Required}}required{{end}}>
@@ -149,6 +182,8 @@ var DEFAULT_FORM = `<form method="post" action="{{ .Action }}" class="text-cente
 </form>`
 var INLINE *template.Template
 var INLINE_FORM = `<form method="post" action="{{ .Action }}" class="form-inline">
+{{ if .Method }}<input type="hidden" name="{{ methodField }}" value="{{ .Method }}">{{ end }}
+{{range .Hidden}}<input type="hidden" name="{{.Name}}" value="{{.Value}}">{{end}}
 <p class="text-error">{{ if .Errors }}{{ range .Inputs }}{{ .Error }}<br/>{{ end }}{{ end }}</p>
 {{range .Inputs}}
     <input class="input{{if .Class}} {{.Class}}{{end}}" type="{{.Type}}" name="{{.Name}}" value="{{.Value}}" placeholder="{{ .Holder }}" {{if .Min}}min="{{.Min}}"{{end}} {{if .Max}}max="{{.Max}}"{{end}} {{if .Required}}required{{end}}>
@@ -10,7 +10,7 @@ import (
 	"container/heap"
 	"fmt"
 	"math/rand"
-	"runtime"
+	"sync"
 	"time"
 )
 
@@ -42,6 +42,12 @@ type Worker struct {
 	i        int
 	requests chan Request
 	pending  int
+	Value    interface{}
+}
+
+// requests assigned to the worker and not yet completed
+func (w *Worker) Pending() int {
+	return w.pending
 }
 
 func (w *Worker) work(done chan *Worker) {
@@ -85,15 +91,31 @@ func (p *Pool) Pop() interface{} {
 	return w
 }
 
+// take the least loaded worker, counting one more pending request
+func (p *Pool) acquire() *Worker {
+	w := heap.Pop(p).(*Worker)
+	w.pending++
+	heap.Push(p, w)
+	return w
+}
+
+// count a request on the worker as completed
+func (p *Pool) release(w *Worker) {
+	w.pending--
+	heap.Remove(p, w.i)
+	heap.Push(p, w)
+}
+
 type Balancer struct {
 	pool Pool
 	done chan *Worker
 	i    int
+	mu   sync.Mutex
 }
 
 func NewBalancer() *Balancer {
 	done := make(chan *Worker, nWorker)
-	b := &Balancer{make(Pool, 0, nWorker), done, 0}
+	b := &Balancer{pool: make(Pool, 0, nWorker), done: done}
 	for i := 0; i < nWorker; i++ {
 		w := &Worker{requests: make(chan Request, nRequester)}
 		heap.Push(&b.pool, w)
@@ -139,11 +161,11 @@ func (b *Balancer) dispatch(req Request) {
 		return
 	}
 
-	w := heap.Pop(&b.pool).(*Worker)
-	w.requests <- req
-	w.pending++
+	b.mu.Lock()
+	w := b.pool.acquire()
 	//	fmt.Printf("started %p; now %d\n", w, w.pending)
-	heap.Push(&b.pool, w)
+	b.mu.Unlock()
+	w.requests <- req
 }
 
 func (b *Balancer) completed(w *Worker) {
@@ -152,10 +174,76 @@ func (b *Balancer) completed(w *Worker) {
 		return
 	}
 
-	w.pending--
+	b.mu.Lock()
+	defer b.mu.Unlock()
 	//	fmt.Printf("finished %p; now %d\n", w, w.pending)
-	heap.Remove(&b.pool, w.i)
-	heap.Push(&b.pool, w)
+	b.pool.release(w)
+}
+
+// total requests dispatched to workers and not yet completed
+func (b *Balancer) Pending() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	sum := 0
+	for _, w := range b.pool {
+		sum += w.pending
+	}
+	return sum
+}
+
+// least pending picker over arbitrary values, using the balancer heap
+// without worker goroutines. callers run the work themselves
+type Picker struct {
+	pool Pool
+	mu   sync.Mutex
+}
+
+// return a new picker over the given values
+func NewPicker(values ...interface{}) *Picker {
+	p := &Picker{pool: make(Pool, 0, len(values))}
+	for _, v := range values {
+		heap.Push(&p.pool, &Worker{Value: v})
+	}
+	return p
+}
+
+// return the worker with the fewest pending requests, call Done when finished
+func (p *Picker) Pick() *Worker {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.pool.acquire()
+}
+
+// return the worker with the fewest pending requests among those whose
+// value ok accepts, or among all of them when it accepts none. call Done
+// when finished
+func (p *Picker) PickFunc(ok func(v interface{}) bool) *Worker {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var best *Worker
+	for _, w := range p.pool {
+		if (best == nil || w.pending < best.pending) && ok(w.Value) {
+			best = w
+		}
+	}
+	if best == nil {
+		return p.pool.acquire()
+	}
+	best.pending++
+	heap.Fix(&p.pool, best.i)
+	return best
+}
+
+// mark a picked request completed
+func (p *Picker) Done(w *Worker) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pool.release(w)
+}
+
+// number of values to pick from
+func (p *Picker) Len() int {
+	return len(p.pool)
 }
 
 /*
@@ -8,11 +8,14 @@ package mail
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"net/smtp"
 	"net/url"
+
+	"github.com/scottcagno/net_kit/trace"
 )
 
 const AUTH_KEY = "038e376187f47b718b9fac83dab476d9ecfb7f3f4955f96135f571c7b9324ba2c3395b"
@@ -20,6 +23,7 @@ const AUTH_KEY = "038e376187f47b718b9fac83dab476d9ecfb7f3f4955f96135f571c7b9324b
 // email structure
 type Email struct {
 	Host_, From_, Reply_, Subject_, To_, Body_ string
+	ctx                                        context.Context
 }
 
 // get new email instance, supply from and string
@@ -49,25 +53,36 @@ func (self *Email) Body(body string) *Email {
 	return self
 }
 
+// set request context, used for trace logging and headers
+func (self *Email) Context(ctx context.Context) *Email {
+	self.ctx = ctx
+	return self
+}
+
 // send mail
 func (self *Email) SendMail() {
+	prefix := trace.Prefix(self.ctx)
 	c, err := smtp.Dial(self.Host_)
 	if err != nil {
 		//c.Reset()
-		log.Fatal(err)
+		log.Fatal(prefix, err)
 	}
 	c.Mail(self.From_)
 	c.Rcpt(self.To_)
 	wc, err := c.Data()
 	if err != nil {
 		c.Reset()
-		log.Fatal(err)
+		log.Fatal(prefix, err)
 	}
 	defer wc.Close()
-	buf := bytes.NewBufferString("To: " + self.To_ + "\nSubject: " + self.Subject_ + "\n" + self.Body_)
+	hdr := "To: " + self.To_ + "\nSubject: " + self.Subject_ + "\n"
+	if t := trace.FromContext(self.ctx); t != nil {
+		hdr += trace.REQUEST_ID + ": " + t.RequestId + "\n"
+	}
+	buf := bytes.NewBufferString(hdr + self.Body_)
 	if _, err = buf.WriteTo(wc); err != nil {
 		c.Reset()
-		log.Fatal(err)
+		log.Fatal(prefix, err)
 	}
 }
 
@@ -107,6 +122,6 @@ func HttpApiHook(w http.ResponseWriter, r *http.Request) {
 		To_:      to,
 		Body_:    DecodeBody(body),
 	}
-	email.SendMail()
+	email.Context(r.Context()).SendMail()
 	fmt.Fprintln(w, "got it, thanks.")
 }
@@ -0,0 +1,335 @@
+// ----------
+// backend.go ::: session storage backends
+// ----------
+// Copyright (c) 2013-Present, Scott Cagno. All rights reserved.
+// This source code is governed by a BSD-style license.
+
+package sess
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// returned by a backend when a session id is unknown or expired
+var ErrNoSession = errors.New("sess: no such session")
+
+// session storage backend, sessions are stored as encoded bytes so a
+// backend need not know anything about their contents
+type SessionBackend interface {
+	// return the stored session, or ErrNoSession
+	Load(sid string) ([]byte, error)
+	// store a session until it expires
+	Save(sid string, data []byte, expires time.Time) error
+	// move a session's expiry without rewriting it, or ErrNoSession
+	Touch(sid string, expires time.Time) error
+	// remove a session, missing sessions are not an error
+	Delete(sid string) error
+	// remove every session expired at now
+	GC(now time.Time) error
+}
+
+// in process session backend, expiry is tracked in a heap ordered by
+// expiry time so a gc pass only visits expired sessions. a store on this
+// backend also keeps each live *Session here, shared by every request
+type MemoryBackend struct {
+	entries map[string]*entry
+	expiry  expiryHeap
+	mu      sync.RWMutex
+}
+
+type entry struct {
+	sid     string
+	data    []byte
+	expires time.Time
+	session *Session
+	i       int
+}
+
+// min-heap of entries by expiry
+type expiryHeap []*entry
+
+func (h expiryHeap) Len() int { return len(h) }
+
+func (h expiryHeap) Less(i, j int) bool {
+	return h[i].expires.Before(h[j].expires)
+}
+
+func (h expiryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].i = i
+	h[j].i = j
+}
+
+func (h *expiryHeap) Push(x interface{}) {
+	e := x.(*entry)
+	e.i = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *expiryHeap) Pop() interface{} {
+	a := *h
+	e := a[len(a)-1]
+	a[len(a)-1] = nil
+	*h = a[:len(a)-1]
+	e.i = -1
+	return e
+}
+
+// return a new memory backend
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{entries: make(map[string]*entry)}
+}
+
+// implement SessionBackend
+func (self *MemoryBackend) Load(sid string) ([]byte, error) {
+	self.mu.RLock()
+	defer self.mu.RUnlock()
+	e, ok := self.entries[sid]
+	if !ok || e.expires.Before(time.Now()) {
+		return nil, ErrNoSession
+	}
+	return e.data, nil
+}
+
+// implement SessionBackend
+func (self *MemoryBackend) Save(sid string, data []byte, expires time.Time) error {
+	return self.put(sid, data, expires, nil)
+}
+
+// store a session along with its live copy, nil drops the live copy
+func (self *MemoryBackend) put(sid string, data []byte, expires time.Time, session *Session) error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if e, ok := self.entries[sid]; ok {
+		e.data, e.expires, e.session = data, expires, session
+		heap.Fix(&self.expiry, e.i)
+		return nil
+	}
+	e := &entry{sid: sid, data: data, expires: expires, session: session}
+	self.entries[sid] = e
+	heap.Push(&self.expiry, e)
+	return nil
+}
+
+// replace a stored session with a new state of its live copy. a session
+// deleted, expired or replaced meanwhile is ErrNoSession, never re-created
+func (self *MemoryBackend) update(sid string, data []byte, expires time.Time, session *Session) error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	e, ok := self.entries[sid]
+	if !ok || e.session != session || e.expires.Before(time.Now()) {
+		return ErrNoSession
+	}
+	e.data, e.expires = data, expires
+	heap.Fix(&self.expiry, e.i)
+	return nil
+}
+
+// return the live copy of an unexpired session, or nil
+func (self *MemoryBackend) live(sid string) *Session {
+	self.mu.RLock()
+	defer self.mu.RUnlock()
+	e, ok := self.entries[sid]
+	if !ok || e.expires.Before(time.Now()) {
+		return nil
+	}
+	return e.session
+}
+
+// keep a session decoded from its stored data as the live copy, unless
+// another request got there first, returning the live copy
+func (self *MemoryBackend) attach(sid string, session *Session) *Session {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	e, ok := self.entries[sid]
+	if !ok {
+		return session
+	}
+	if e.session == nil {
+		e.session = session
+	}
+	return e.session
+}
+
+// implement SessionBackend
+func (self *MemoryBackend) Touch(sid string, expires time.Time) error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	e, ok := self.entries[sid]
+	if !ok || e.expires.Before(time.Now()) {
+		return ErrNoSession
+	}
+	e.expires = expires
+	heap.Fix(&self.expiry, e.i)
+	return nil
+}
+
+// implement SessionBackend
+func (self *MemoryBackend) Delete(sid string) error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if e, ok := self.entries[sid]; ok {
+		heap.Remove(&self.expiry, e.i)
+		delete(self.entries, sid)
+	}
+	return nil
+}
+
+// implement SessionBackend
+func (self *MemoryBackend) GC(now time.Time) error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	for len(self.expiry) > 0 && self.expiry[0].expires.Before(now) {
+		e := heap.Pop(&self.expiry).(*entry)
+		delete(self.entries, e.sid)
+	}
+	return nil
+}
+
+// number of stored sessions
+func (self *MemoryBackend) Len() int {
+	self.mu.RLock()
+	defer self.mu.RUnlock()
+	return len(self.entries)
+}
+
+// call fn for every stored session
+func (self *MemoryBackend) Range(fn func(sid string, data []byte, expires time.Time)) {
+	self.mu.RLock()
+	defer self.mu.RUnlock()
+	for sid, e := range self.entries {
+		fn(sid, e.data, e.expires)
+	}
+}
+
+// filesystem session backend, one file per session holding the expiry
+// followed by the session data
+type FileBackend struct {
+	dir string
+}
+
+// return a new file backend storing sessions in dir
+func NewFileBackend(dir string) (*FileBackend, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileBackend{dir}, nil
+}
+
+// session file path, ids are restricted to the Random alphabet
+func (self *FileBackend) path(sid string) (string, error) {
+	if sid == "" || strings.IndexFunc(sid, func(c rune) bool {
+		return !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_')
+	}) >= 0 {
+		return "", ErrNoSession
+	}
+	return filepath.Join(self.dir, sid+".sess"), nil
+}
+
+// implement SessionBackend
+func (self *FileBackend) Load(sid string) ([]byte, error) {
+	path, err := self.path(sid)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNoSession
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < 8 || time.Unix(int64(binary.BigEndian.Uint64(b)), 0).Before(time.Now()) {
+		return nil, ErrNoSession
+	}
+	return b[8:], nil
+}
+
+// implement SessionBackend, written to a temporary file then renamed so
+// readers never see a partial session
+func (self *FileBackend) Save(sid string, data []byte, expires time.Time) error {
+	path, err := self.path(sid)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(self.dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	b := make([]byte, 8, 8+len(data))
+	binary.BigEndian.PutUint64(b, uint64(expires.Unix()))
+	if _, err = f.Write(append(b, data...)); err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// implement SessionBackend, rewriting only the expiry in place
+func (self *FileBackend) Touch(sid string, expires time.Time) error {
+	path, err := self.path(sid)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if os.IsNotExist(err) {
+		return ErrNoSession
+	}
+	if err != nil {
+		return err
+	}
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(expires.Unix()))
+	_, err = f.WriteAt(b, 0)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// implement SessionBackend
+func (self *FileBackend) Delete(sid string) error {
+	path, err := self.path(sid)
+	if err != nil {
+		return nil
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// implement SessionBackend
+func (self *FileBackend) GC(now time.Time) error {
+	paths, err := filepath.Glob(filepath.Join(self.dir, "*.sess"))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		b := make([]byte, 8)
+		_, err = f.Read(b)
+		f.Close()
+		if err != nil || time.Unix(int64(binary.BigEndian.Uint64(b)), 0).Before(now) {
+			os.Remove(path)
+		}
+	}
+	return nil
+}
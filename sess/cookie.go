@@ -0,0 +1,143 @@
+// ---------
+// cookie.go ::: encrypted cookie sessions
+// ---------
+// Copyright (c) 2013-Present, Scott Cagno. All rights reserved.
+// This source code is governed by a BSD-style license.
+
+package sess
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// browsers keep at least 4096 bytes per cookie, name and attributes included
+const COOKIE_MAX_SIZE = 4000
+
+var (
+	ErrNoKeys         = errors.New("sess: cookie codec needs at least one key")
+	ErrCookieTooLarge = errors.New("sess: session too large for a cookie")
+	ErrCookieInvalid  = errors.New("sess: cookie failed authentication")
+	ErrCookieExpired  = errors.New("sess: cookie expired")
+)
+
+// authenticated encryption of session cookies using aes-gcm. the first key
+// encrypts, every key is tried when decrypting so old keys can be rotated out
+type CookieCodec struct {
+	MaxSize int
+	aeads   []cipher.AEAD
+}
+
+// return a new codec, keys must be 16, 24 or 32 bytes
+func NewCookieCodec(keys ...[]byte) (*CookieCodec, error) {
+	if len(keys) == 0 {
+		return nil, ErrNoKeys
+	}
+	codec := &CookieCodec{MaxSize: COOKIE_MAX_SIZE}
+	for _, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		codec.aeads = append(codec.aeads, aead)
+	}
+	return codec, nil
+}
+
+// seal data and its expiry into a cookie value bound to the cookie name
+func (self *CookieCodec) Encode(name string, data []byte, expires time.Time) (string, error) {
+	aead := self.aeads[0]
+	plain := make([]byte, 8, 8+len(data))
+	binary.BigEndian.PutUint64(plain, uint64(expires.Unix()))
+	plain = append(plain, data...)
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plain)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	value := base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, plain, []byte(name)))
+	if size := len(name) + 1 + len(value); self.MaxSize > 0 && size > self.MaxSize {
+		return "", fmt.Errorf("%w: %d bytes encoded, limit is %d", ErrCookieTooLarge, size, self.MaxSize)
+	}
+	return value, nil
+}
+
+// open a cookie value, checking authenticity and expiry
+func (self *CookieCodec) Decode(name, value string) ([]byte, error) {
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrCookieInvalid
+	}
+	for _, aead := range self.aeads {
+		n := aead.NonceSize()
+		if len(b) < n+aead.Overhead()+8 {
+			continue
+		}
+		plain, err := aead.Open(nil, b[:n], b[n:], []byte(name))
+		if err != nil {
+			continue
+		}
+		if time.Unix(int64(binary.BigEndian.Uint64(plain)), 0).Before(time.Now()) {
+			return nil, ErrCookieExpired
+		}
+		return plain[8:], nil
+	}
+	return nil, ErrCookieInvalid
+}
+
+// return a session store keeping the session values in the cookie itself,
+// sealed by codec. values must be set before the response body is written
+func NewCookieStore(cookieId string, rate int64, codec *CookieCodec) *Store {
+	return &Store{
+		Cookie:   DefaultCookieOptions(),
+		Remember: MONTH,
+		cookieId: cookieId,
+		rate:     rate,
+		codec:    codec,
+	}
+}
+
+// read the session sealed in the request cookie, or start a fresh one
+func (self *Store) cookieSession(w http.ResponseWriter, r *http.Request) *Session {
+	if cookie, err := r.Cookie(self.cookieId); err == nil && cookie.Value != "" {
+		b, err := self.codec.Decode(self.cookieId, cookie.Value)
+		if err == nil {
+			rec, err := self.decode(b)
+			if err == nil && rec.Sid != "" && self.expires(rec.Ts, rec.Created, rec.Remember).After(time.Now()) {
+				session := rec.session(self)
+				session.w = w
+				return session
+			}
+		}
+	}
+	session := self.FreshSession(Random(32))
+	session.w = w
+	self.create(session)
+	return session
+}
+
+// seal an encoded session into its cookie, replacing one set earlier in
+// the response. the caller holds the session lock
+func (self *Store) saveCookie(session *Session, b []byte) error {
+	if session.w == nil {
+		return errors.New("sess: cookie session has no response to write to")
+	}
+	value, err := self.codec.Encode(self.cookieId, b, self.expires(session.ts, session.created, session.remember))
+	if err != nil {
+		return err
+	}
+	cookie := self.sessionCookie("", session.remember)
+	cookie.Value = value
+	self.setCookie(session.w, &cookie)
+	return nil
+}
@@ -0,0 +1,76 @@
+// --------------
+// cookie_test.go ::: cookie codec tests
+// --------------
+// Copyright (c) 2013-Present, Scott Cagno. All rights reserved.
+// This source code is governed by a BSD-style license.
+
+package sess
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+)
+
+var (
+	oldKey = bytes.Repeat([]byte{1}, 32)
+	newKey = bytes.Repeat([]byte{2}, 32)
+)
+
+func codec(t *testing.T, keys ...[]byte) *CookieCodec {
+	c, err := NewCookieCodec(keys...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// values sealed with a rotated out key still open while it is listed
+func TestCookieCodecRotation(t *testing.T) {
+	expires := time.Now().Add(time.Hour)
+	value, err := codec(t, oldKey).Encode("sid", []byte("alice"), expires)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := codec(t, newKey, oldKey).Decode("sid", value); err != nil || string(data) != "alice" {
+		t.Fatalf("expected alice, got %q %v", data, err)
+	}
+	if _, err := codec(t, newKey).Decode("sid", value); err != ErrCookieInvalid {
+		t.Fatalf("dropped key still opens, got %v", err)
+	}
+	if _, err := codec(t, oldKey).Decode("other", value); err != ErrCookieInvalid {
+		t.Fatalf("value opened under another cookie name, got %v", err)
+	}
+}
+
+// altered, truncated and expired values are refused
+func TestCookieCodecRefused(t *testing.T) {
+	c := codec(t, newKey)
+	value, _ := c.Encode("sid", []byte("alice"), time.Now().Add(time.Hour))
+	b, _ := base64.RawURLEncoding.DecodeString(value)
+	b[len(b)-1] ^= 1
+	for _, v := range []string{base64.RawURLEncoding.EncodeToString(b), value[:10], "not base64!"} {
+		if _, err := c.Decode("sid", v); err != ErrCookieInvalid {
+			t.Fatalf("tampered value: expected ErrCookieInvalid, got %v", err)
+		}
+	}
+	expired, _ := c.Encode("sid", []byte("alice"), time.Now().Add(-time.Second))
+	if _, err := c.Decode("sid", expired); err != ErrCookieExpired {
+		t.Fatalf("expected ErrCookieExpired, got %v", err)
+	}
+}
+
+// values over MaxSize are refused when encoding
+func TestCookieCodecTooLarge(t *testing.T) {
+	c := codec(t, newKey)
+	_, err := c.Encode("sid", make([]byte, COOKIE_MAX_SIZE), time.Now().Add(time.Hour))
+	if !errors.Is(err, ErrCookieTooLarge) {
+		t.Fatalf("expected ErrCookieTooLarge, got %v", err)
+	}
+	c.MaxSize = 0
+	if _, err := c.Encode("sid", make([]byte, COOKIE_MAX_SIZE), time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+}
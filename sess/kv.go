@@ -0,0 +1,339 @@
+// -----
+// kv.go ::: tcp key-value session backend
+// -----
+// Copyright (c) 2013-Present, Scott Cagno. All rights reserved.
+// This source code is governed by a BSD-style license.
+
+package sess
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// largest session the key-value protocol will carry
+const KV_MAX_SIZE = 1 << 20
+
+var (
+	errKVLength = errors.New("bad payload length")
+	errKVKey    = errors.New("sess: kv: invalid key")
+)
+
+// key-value server sharing one backend between several nodes. the protocol
+// is line based, each command answered with "OK", "OK <n>" followed by n
+// bytes, "NONE" or "ERR <msg>"
+//
+//	AUTH <secret>
+//	GET <sid>
+//	SET <sid> <expires> <n>\n<n bytes>
+//	TOUCH <sid> <expires>
+//	DEL <sid>
+//	GC <now>
+//
+// anyone reaching the server can read and forge sessions. bind it to a
+// private interface or unix socket only, and set Secret so connections
+// must send AUTH first. the protocol is not encrypted, the secret guards
+// against stray clients, not eavesdroppers
+type KVServer struct {
+	Secret  string
+	backend SessionBackend
+}
+
+// return a new key-value server in front of backend
+func NewKVServer(backend SessionBackend) *KVServer {
+	return &KVServer{backend: backend}
+}
+
+// listen on a tcp address and serve
+func (self *KVServer) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return self.Serve(l)
+}
+
+// accept and serve connections until the listener is closed
+func (self *KVServer) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go self.serve(conn)
+	}
+}
+
+// serve a single connection
+func (self *KVServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r, w := bufio.NewReader(conn), bufio.NewWriter(conn)
+	authed := self.Secret == ""
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("sess: kv: %v\n", err)
+			}
+			return
+		}
+		args := strings.Fields(line)
+		if len(args) == 0 {
+			continue
+		}
+		if args[0] == "AUTH" || !authed {
+			if len(args) != 2 || args[0] != "AUTH" || !self.auth(args[1]) {
+				// refuse and hang up, one guess per connection
+				w.WriteString("ERR auth required\n")
+				w.Flush()
+				return
+			}
+			authed = true
+			w.WriteString("OK\n")
+			if w.Flush() != nil {
+				return
+			}
+			continue
+		}
+		err = self.exec(args, r, w)
+		if err != nil {
+			fmt.Fprintf(w, "ERR %s\n", strings.Replace(err.Error(), "\n", " ", -1))
+		}
+		if w.Flush() != nil || err == errKVLength {
+			// an unread payload leaves the stream out of step
+			return
+		}
+	}
+}
+
+// report whether secret matches, anything does without a Secret
+func (self *KVServer) auth(secret string) bool {
+	return self.Secret == "" || subtle.ConstantTimeCompare([]byte(secret), []byte(self.Secret)) == 1
+}
+
+// execute a single command
+func (self *KVServer) exec(args []string, r *bufio.Reader, w *bufio.Writer) error {
+	switch {
+	case args[0] == "GET" && len(args) == 2:
+		b, err := self.backend.Load(args[1])
+		if err == ErrNoSession {
+			_, err = w.WriteString("NONE\n")
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "OK %d\n", len(b))
+		_, err = w.Write(b)
+		return err
+	case args[0] == "SET" && len(args) == 4:
+		expires, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return err
+		}
+		n, err := strconv.Atoi(args[3])
+		if err != nil || n < 0 || n > KV_MAX_SIZE {
+			return errKVLength
+		}
+		b := make([]byte, n)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return err
+		}
+		if err := self.backend.Save(args[1], b, time.Unix(expires, 0)); err != nil {
+			return err
+		}
+	case args[0] == "TOUCH" && len(args) == 3:
+		expires, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return err
+		}
+		err = self.backend.Touch(args[1], time.Unix(expires, 0))
+		if err == ErrNoSession {
+			_, err = w.WriteString("NONE\n")
+			return err
+		}
+		if err != nil {
+			return err
+		}
+	case args[0] == "DEL" && len(args) == 2:
+		if err := self.backend.Delete(args[1]); err != nil {
+			return err
+		}
+	case args[0] == "GC" && len(args) == 2:
+		now, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return err
+		}
+		if err := self.backend.GC(time.Unix(now, 0)); err != nil {
+			return err
+		}
+	default:
+		return errors.New("unknown command")
+	}
+	_, err := w.WriteString("OK\n")
+	return err
+}
+
+// session backend talking to a KVServer, Secret is sent with AUTH on
+// every new connection when set
+type KVBackend struct {
+	Secret  string
+	addr    string
+	timeout time.Duration
+	idle    []*kvConn
+	mu      sync.Mutex
+}
+
+type kvConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// return a new key-value backend for the server at addr
+func NewKVBackend(addr string, timeout time.Duration) *KVBackend {
+	return &KVBackend{addr: addr, timeout: timeout}
+}
+
+// implement SessionBackend
+func (self *KVBackend) Load(sid string) ([]byte, error) {
+	var data []byte
+	err := self.do(fmt.Sprintf("GET %s\n", sid), nil, func(reply string, r *bufio.Reader) error {
+		if reply == "NONE" {
+			return ErrNoSession
+		}
+		n, err := strconv.Atoi(strings.TrimPrefix(reply, "OK "))
+		if err != nil || n < 0 || n > KV_MAX_SIZE {
+			return fmt.Errorf("sess: kv: bad reply %q", reply)
+		}
+		data = make([]byte, n)
+		_, err = io.ReadFull(r, data)
+		return err
+	})
+	return data, err
+}
+
+// implement SessionBackend
+func (self *KVBackend) Save(sid string, data []byte, expires time.Time) error {
+	if len(data) > KV_MAX_SIZE {
+		return fmt.Errorf("sess: kv: session of %d bytes exceeds limit", len(data))
+	}
+	return self.do(fmt.Sprintf("SET %s %d %d\n", sid, expires.Unix(), len(data)), data, nil)
+}
+
+// implement SessionBackend
+func (self *KVBackend) Touch(sid string, expires time.Time) error {
+	return self.do(fmt.Sprintf("TOUCH %s %d\n", sid, expires.Unix()), nil, func(reply string, r *bufio.Reader) error {
+		if reply == "NONE" {
+			return ErrNoSession
+		}
+		return nil
+	})
+}
+
+// implement SessionBackend
+func (self *KVBackend) Delete(sid string) error {
+	return self.do(fmt.Sprintf("DEL %s\n", sid), nil, nil)
+}
+
+// implement SessionBackend
+func (self *KVBackend) GC(now time.Time) error {
+	return self.do(fmt.Sprintf("GC %d\n", now.Unix()), nil, nil)
+}
+
+// send a command on a pooled connection and read its reply
+func (self *KVBackend) do(cmd string, payload []byte, fn func(reply string, r *bufio.Reader) error) error {
+	if strings.ContainsAny(strings.TrimSuffix(cmd, "\n"), "\r\n") {
+		return errKVKey
+	}
+	conn, err := self.get()
+	if err != nil {
+		return err
+	}
+	if self.timeout > 0 {
+		conn.SetDeadline(time.Now().Add(self.timeout))
+	}
+	err = func() error {
+		if _, err := io.WriteString(conn, cmd); err != nil {
+			return err
+		}
+		if payload != nil {
+			if _, err := conn.Write(payload); err != nil {
+				return err
+			}
+		}
+		reply, err := conn.r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		reply = strings.TrimSpace(reply)
+		if strings.HasPrefix(reply, "ERR ") {
+			return errors.New("sess: kv: " + reply[4:])
+		}
+		if fn != nil {
+			return fn(reply, conn.r)
+		}
+		return nil
+	}()
+	if err != nil && err != ErrNoSession {
+		// the connection state is unknown after a failure
+		conn.Close()
+		return err
+	}
+	self.put(conn)
+	return err
+}
+
+// take an idle connection or dial a new one
+func (self *KVBackend) get() (*kvConn, error) {
+	self.mu.Lock()
+	if n := len(self.idle); n > 0 {
+		conn := self.idle[n-1]
+		self.idle = self.idle[:n-1]
+		self.mu.Unlock()
+		return conn, nil
+	}
+	self.mu.Unlock()
+	if strings.ContainsAny(self.Secret, " \t\r\n") {
+		return nil, errors.New("sess: kv: secret holds whitespace")
+	}
+	conn, err := net.DialTimeout("tcp", self.addr, self.timeout)
+	if err != nil {
+		return nil, err
+	}
+	kc := &kvConn{conn, bufio.NewReader(conn)}
+	if self.Secret == "" {
+		return kc, nil
+	}
+	if self.timeout > 0 {
+		conn.SetDeadline(time.Now().Add(self.timeout))
+	}
+	var reply string
+	if _, err = io.WriteString(conn, "AUTH "+self.Secret+"\n"); err == nil {
+		reply, err = kc.r.ReadString('\n')
+	}
+	if err == nil && strings.TrimSpace(reply) != "OK" {
+		err = errors.New("sess: kv: auth refused")
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return kc, nil
+}
+
+// return a connection to the idle pool
+func (self *KVBackend) put(conn *kvConn) {
+	conn.SetDeadline(time.Time{})
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	self.idle = append(self.idle, conn)
+}
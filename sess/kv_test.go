@@ -0,0 +1,41 @@
+// ----------
+// kv_test.go ::: key-value session backend tests
+// ----------
+// Copyright (c) 2013-Present, Scott Cagno. All rights reserved.
+// This source code is governed by a BSD-style license.
+
+package sess
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// a server with a secret serves only clients sending it
+func TestKVSecret(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	server := NewKVServer(NewMemoryBackend())
+	server.Secret = "s3cret"
+	go server.Serve(l)
+	expires := time.Now().Add(time.Hour)
+	for _, secret := range []string{"", "guess"} {
+		client := NewKVBackend(l.Addr().String(), time.Second)
+		client.Secret = secret
+		if err := client.Save("sid", []byte("alice"), expires); err == nil {
+			t.Fatalf("secret %q accepted", secret)
+		}
+	}
+	client := NewKVBackend(l.Addr().String(), time.Second)
+	client.Secret = "s3cret"
+	if err := client.Save("sid", []byte("alice"), expires); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := client.Load("sid"); err != nil || string(data) != "alice" {
+		t.Fatalf("expected alice, got %q %v", data, err)
+	}
+}
@@ -7,9 +7,12 @@
 package sess
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/base64"
 	"fmt"
+	"hash/fnv"
+	"log"
 	"net/http"
 	"net/url"
 	"strings"
@@ -27,39 +30,102 @@ const (
 	SESSION = 0
 )
 
+// server side idle timeout for sessions whose cookie lasts the browser
+// session, the browser never tells us when it is closed
+const SESSION_IDLE = DAY
+
+// session cookie attributes
+type CookieOptions struct {
+	Path     string
+	Domain   string
+	Secure   bool
+	HttpOnly bool
+	SameSite http.SameSite
+}
+
+// return the default cookie options, http only and same site lax
+func DefaultCookieOptions() CookieOptions {
+	return CookieOptions{
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// sessions expire rate seconds after last use, or with rate SESSION when the
+// browser closes. Absolute, when set, caps a session's total lifetime in
+// seconds. remembered sessions last Remember seconds since last use instead
 type Store struct {
-	cookieId string
-	rate     int64
-	sessions map[string]*Session
-	mu       sync.Mutex
+	Grace      time.Duration
+	Serializer Serializer
+	Cookie     CookieOptions
+	Absolute   int64
+	Remember   int64
+	cookieId   string
+	rate       int64
+	backend    SessionBackend
+	codec      *CookieCodec
+	stop       chan struct{}
+	snapshot   string
+	locks      [32]sync.Mutex
+	mu         sync.Mutex
 }
 
 func NewSessionStore(cookieId string, rate int64) *Store {
+	return NewBackendStore(cookieId, rate, NewMemoryBackend())
+}
+
+// return a session store keeping its sessions in the given backend
+func NewBackendStore(cookieId string, rate int64, backend SessionBackend) *Store {
 	store := &Store{
+		Cookie:   DefaultCookieOptions(),
+		Remember: MONTH,
 		cookieId: cookieId,
 		rate:     rate,
-		sessions: make(map[string]*Session, 0),
+		backend:  backend,
+		stop:     make(chan struct{}),
 	}
-	store.GC()
+	go store.collect(store.stop)
 	return store
 }
 
+func (self *Store) Backend() SessionBackend {
+	return self.backend
+}
+
 func (self *Store) FreshCookie(sid string) http.Cookie {
 	return http.Cookie{
 		Name:     self.cookieId,
 		Value:    url.QueryEscape(sid),
-		Path:     "/",
-		HttpOnly: true,
+		Path:     self.Cookie.Path,
+		Domain:   self.Cookie.Domain,
+		Secure:   self.Cookie.Secure,
+		HttpOnly: self.Cookie.HttpOnly,
+		SameSite: self.Cookie.SameSite,
 		MaxAge:   int(self.rate),
 	}
 }
 
+// cookie for an existing session, lasting as long as a remembered one
+func (self *Store) sessionCookie(sid string, remember bool) http.Cookie {
+	cookie := self.FreshCookie(sid)
+	if remember {
+		cookie.MaxAge = int(self.Remember)
+	}
+	if cookie.MaxAge > 0 {
+		cookie.Expires = time.Now().Add(time.Duration(cookie.MaxAge) * time.Second)
+	}
+	return cookie
+}
+
 func (self *Store) FreshSession(sid string) *Session {
+	now := time.Now()
 	return &Session{
-		sid:   sid,
-		store: self,
-		ts:    time.Now(),
-		vals:  make(map[string][]string, 0),
+		sid:     sid,
+		store:   self,
+		ts:      now,
+		created: now,
+		vals:    make(map[string]interface{}, 0),
 	}
 }
 
@@ -68,30 +134,77 @@ func (self *Store) NewSession(w http.ResponseWriter, r *http.Request) *Session {
 	defer self.mu.Unlock()
 	sid := Random(32)
 	session := self.FreshSession(sid)
-	self.sessions[sid] = session
+	if self.codec != nil {
+		session.w = w
+		self.create(session)
+		return session
+	}
+	self.create(session)
 	cookie := self.FreshCookie(sid)
-	http.SetCookie(w, &cookie)
+	self.setCookie(w, &cookie)
 	return session
 }
 
 func (self *Store) GetSession(w http.ResponseWriter, r *http.Request) *Session {
+	if session, ok := r.Context().Value(sessionKey{self}).(*Session); ok {
+		if self.codec != nil {
+			session.mu.Lock()
+			session.w = w
+			session.mu.Unlock()
+		}
+		return session
+	}
 	self.mu.Lock()
 	defer self.mu.Unlock()
+	if self.codec != nil {
+		return self.cookieSession(w, r)
+	}
 	var session *Session
 	cookie, err := r.Cookie(self.cookieId)
 	if err != nil || cookie.Value == "" {
 		sid := Random(32)
 		session = self.FreshSession(sid)
-		self.sessions[sid] = session
+		self.create(session)
 		cookie := self.FreshCookie(sid)
-		http.SetCookie(w, &cookie)
+		self.setCookie(w, &cookie)
 	} else {
 		sid, _ := url.QueryUnescape(cookie.Value)
-		session = self.sessions[sid]
+		if session = self.load(sid); session == nil {
+			// unknown or expired, start over under a new id
+			session = self.FreshSession(Random(32))
+			self.create(session)
+			cookie := self.FreshCookie(session.sid)
+			self.setCookie(w, &cookie)
+		}
 	}
 	return session
 }
 
+// context key of a session attached to a request
+type sessionKey struct {
+	store *Store
+}
+
+// return a copy of r whose GetSession calls return session, so a session
+// created or regenerated early in a request is the one the rest of it uses
+func (self *Store) WithSession(r *http.Request, session *Session) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), sessionKey{self}, session))
+}
+
+// set the session cookie, replacing one set earlier in the response
+func (self *Store) setCookie(w http.ResponseWriter, cookie *http.Cookie) {
+	header := w.Header()
+	prefix := self.cookieId + "="
+	kept := header["Set-Cookie"][:0]
+	for _, c := range header["Set-Cookie"] {
+		if !strings.HasPrefix(c, prefix) {
+			kept = append(kept, c)
+		}
+	}
+	header["Set-Cookie"] = kept
+	http.SetCookie(w, cookie)
+}
+
 func (self *Store) DelSession(w http.ResponseWriter, r *http.Request) {
 	cookie, err := r.Cookie(self.cookieId)
 	if err != nil && err == http.ErrNoCookie || cookie.Value == "" {
@@ -100,11 +213,73 @@ func (self *Store) DelSession(w http.ResponseWriter, r *http.Request) {
 	self.mu.Lock()
 	defer self.mu.Unlock()
 	sid, _ := url.QueryUnescape(cookie.Value)
-	delete(self.sessions, sid)
+	if self.backend != nil {
+		mu := self.lock(sid)
+		mu.Lock()
+		err := self.backend.Delete(sid)
+		mu.Unlock()
+		if err != nil {
+			log.Printf("sess: delete: %v\n", err)
+		}
+	}
 	*cookie = self.FreshCookie(sid)
 	cookie.MaxAge = -1
 	cookie.Expires = time.Now()
-	http.SetCookie(w, cookie)
+	self.setCookie(w, cookie)
+}
+
+// move the request's session to a fresh id, ie. after login. the old id
+// is invalidated, or with a Grace window keeps resolving to the new session
+// for a moment so concurrent requests carrying it don't lose their session.
+// writes from requests still holding the old id are dropped either way.
+// old cookie sessions cannot be revoked and stay valid until they expire
+func (self *Store) Regenerate(w http.ResponseWriter, r *http.Request) *Session {
+	session := self.GetSession(w, r)
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	session.mu.Lock()
+	old, sid, now := session.sid, Random(32), time.Now()
+	session.sid, session.ts, session.created = sid, now, now
+	remember := session.remember
+	session.mu.Unlock()
+	self.create(session)
+	if self.codec != nil {
+		return session
+	}
+	mu := self.lock(old)
+	mu.Lock()
+	var err error
+	if self.Grace > 0 {
+		var b []byte
+		if b, err = self.serializer().Marshal(record{Sid: old, Ts: now, Moved: sid}); err == nil {
+			err = self.backend.Save(old, b, now.Add(self.Grace))
+		}
+	} else {
+		err = self.backend.Delete(old)
+	}
+	mu.Unlock()
+	if err != nil {
+		log.Printf("sess: regenerate: %v\n", err)
+	}
+	cookie := self.sessionCookie(sid, remember)
+	self.setCookie(w, &cookie)
+	return session
+}
+
+// mark the request's session remembered, keeping it and its cookie alive
+// for Remember seconds since last use, or turn that off again
+func (self *Store) RememberMe(w http.ResponseWriter, r *http.Request, on bool) *Session {
+	session := self.GetSession(w, r)
+	session.mu.Lock()
+	session.remember = on
+	sid := session.sid
+	session.mu.Unlock()
+	self.save(session)
+	if self.codec == nil {
+		cookie := self.sessionCookie(sid, on)
+		self.setCookie(w, &cookie)
+	}
+	return session
 }
 
 func (self *Store) ExtSession(w http.ResponseWriter, r *http.Request) {
@@ -114,96 +289,317 @@ func (self *Store) ExtSession(w http.ResponseWriter, r *http.Request) {
 	}
 	self.mu.Lock()
 	defer self.mu.Unlock()
+	if self.codec != nil {
+		self.refresh(self.cookieSession(w, r))
+		return
+	}
 	sid, _ := url.QueryUnescape(cookie.Value)
-	if session, ok := self.sessions[sid]; ok {
-		*cookie = self.FreshCookie(sid)
-		currentTime := time.Now()
-		session.ts = currentTime
-		cookie.Expires = currentTime.Add(time.Duration(self.rate) * time.Second)
-		http.SetCookie(w, cookie)
+	if session := self.load(sid); session != nil {
+		self.refresh(session)
+		*cookie = self.sessionCookie(session.sid, session.remember)
+		self.setCookie(w, cookie)
 	}
 }
 
 func (self *Store) Update(sid string) {
 	self.mu.Lock()
 	defer self.mu.Unlock()
-	if session, ok := self.sessions[sid]; ok {
-		session.ts = time.Now()
+	if session := self.load(sid); session != nil {
+		self.refresh(session)
 	}
 }
 
+// load a session from the backend, nil if unknown. the memory backend
+// hands every request the same live session
+func (self *Store) load(sid string) *Session {
+	if self.backend == nil {
+		return nil
+	}
+	mb, shared := self.backend.(*MemoryBackend)
+	if shared {
+		if session := mb.live(sid); session != nil {
+			session.mu.Lock()
+			created := session.created
+			session.mu.Unlock()
+			if self.outlived(created) {
+				mb.Delete(sid)
+				return nil
+			}
+			return session
+		}
+	}
+	b, err := self.backend.Load(sid)
+	if err != nil {
+		if err != ErrNoSession {
+			log.Printf("sess: load: %v\n", err)
+		}
+		return nil
+	}
+	rec, err := self.decode(b)
+	if err != nil {
+		log.Printf("sess: decode: %v\n", err)
+		return nil
+	}
+	if rec.Moved != "" {
+		// regenerated, still within its grace window
+		if rec.Moved == sid {
+			return nil
+		}
+		return self.load(rec.Moved)
+	}
+	// idle expiry is the backend's, it may not have collected it yet
+	if self.outlived(rec.Created) {
+		self.backend.Delete(sid)
+		return nil
+	}
+	session := rec.session(self)
+	session.sid = sid
+	if shared {
+		session = mb.attach(sid, session)
+	}
+	return session
+}
+
+// report whether a session started at created is past the Absolute limit
+func (self *Store) outlived(created time.Time) bool {
+	return self.Absolute > 0 && !created.IsZero() &&
+		created.Add(time.Duration(self.Absolute)*time.Second).Before(time.Now())
+}
+
+// store a new session, or one moved to a new id, in full
+func (self *Store) create(session *Session) {
+	self.write(session, true)
+}
+
+// write a changed session to the backend or its cookie. other backends
+// than memory get just the changed keys merged in. a session deleted or
+// moved to a new id meanwhile is never brought back, the write is dropped
+// and Err reports ErrNoSession
+func (self *Store) save(session *Session) {
+	self.write(session, false)
+}
+
+// write a session holding its lock, so concurrent writes land in order
+func (self *Store) write(session *Session, create bool) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	session.ts = time.Now()
+	expires := self.expires(session.ts, session.created, session.remember)
+	mb, shared := self.backend.(*MemoryBackend)
+	var err error
+	if self.codec == nil && !shared && !create {
+		err = self.merge(session, expires)
+	} else {
+		var b []byte
+		if b, err = self.encode(session); err == nil {
+			switch {
+			case self.codec != nil:
+				err = self.saveCookie(session, b)
+			case !shared:
+				err = self.backend.Save(session.sid, b, expires)
+			case create:
+				err = mb.put(session.sid, b, expires, session)
+			default:
+				err = mb.update(session.sid, b, expires, session)
+			}
+		}
+	}
+	if err == nil {
+		session.dirty = nil
+	}
+	if session.err = err; err != nil {
+		log.Printf("sess: save: %v\n", err)
+	}
+}
+
+// lock serialising writes to a stored session id
+func (self *Store) lock(sid string) *sync.Mutex {
+	h := fnv.New32a()
+	h.Write([]byte(sid))
+	return &self.locks[h.Sum32()%uint32(len(self.locks))]
+}
+
+// refresh a session's expiry on use, at most once every sixtieth of its
+// idle timeout so reads stay cheap
+func (self *Store) touch(session *Session) {
+	session.mu.Lock()
+	idle := self.expires(session.ts, time.Time{}, session.remember).Sub(session.ts)
+	fresh := time.Since(session.ts) < idle/60
+	session.mu.Unlock()
+	if !fresh {
+		self.refresh(session)
+	}
+}
+
+// move a session's expiry a full idle timeout ahead without writing its
+// values, a cookie session has to be sealed again
+func (self *Store) refresh(session *Session) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	session.ts = time.Now()
+	var err error
+	if self.codec != nil {
+		var b []byte
+		if b, err = self.encode(session); err == nil {
+			err = self.saveCookie(session, b)
+		}
+	} else {
+		err = self.backend.Touch(session.sid, self.expires(session.ts, session.created, session.remember))
+		if err == ErrNoSession {
+			// deleted or expired meanwhile, not this request's doing
+			return
+		}
+	}
+	if session.err = err; err != nil {
+		log.Printf("sess: touch: %v\n", err)
+	}
+}
+
+// expiry of a session last used at ts and started at created
+func (self *Store) expires(ts, created time.Time, remember bool) time.Time {
+	idle := self.rate
+	switch {
+	case remember:
+		return ts.Add(time.Duration(self.Remember) * time.Second)
+	case idle == SESSION:
+		idle = SESSION_IDLE
+	}
+	expires := ts.Add(time.Duration(idle) * time.Second)
+	if self.Absolute > 0 && !created.IsZero() {
+		if limit := created.Add(time.Duration(self.Absolute) * time.Second); limit.Before(expires) {
+			return limit
+		}
+	}
+	return expires
+}
+
+// remove expired sessions from the backend
 func (self *Store) GC() {
+	if self.backend == nil {
+		return
+	}
+	if err := self.backend.GC(time.Now()); err != nil {
+		log.Printf("sess: gc: %v\n", err)
+	}
+}
+
+// run GC every rate seconds, but at least once a minute, until stopped
+func (self *Store) collect(stop chan struct{}) {
+	interval := time.Duration(self.rate) * time.Second
+	if interval <= 0 || interval > MIN*time.Second {
+		interval = MIN * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			self.GC()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// stop the background gc and snapshot goroutines, writing a final
+// snapshot if the store persists. call it on graceful shutdown
+func (self *Store) Stop() {
 	self.mu.Lock()
 	defer self.mu.Unlock()
-	for sid, session := range self.sessions {
-		if (session.ts.Unix() + self.rate) < time.Now().Unix() {
-			delete(self.sessions, sid)
-		} else {
-			break
-		}
+	if self.stop == nil {
+		return
+	}
+	close(self.stop)
+	self.stop = nil
+	if self.snapshot != "" {
+		self.writeSnapshot()
 	}
-	time.AfterFunc(time.Duration(self.rate)*time.Second, func() {
-		self.GC()
-	})
+}
+
+// number of stored sessions, for backends able to count them
+func (self *Store) Len() int {
+	if b, ok := self.backend.(interface{ Len() int }); ok {
+		return b.Len()
+	}
+	return 0
 }
 
 func (self *Store) ViewSessions() {
-	for k, v := range self.sessions {
-		fmt.Printf("key: %v\nval: %v\n\n", k, v)
+	if b, ok := self.backend.(*MemoryBackend); ok {
+		b.Range(func(sid string, data []byte, expires time.Time) {
+			fmt.Printf("key: %v\nval: %s\n\n", sid, data)
+		})
 	}
 }
 
 type Session struct {
-	sid   string
-	store *Store
-	ts    time.Time
-	vals  map[string][]string
+	sid      string
+	store    *Store
+	ts       time.Time
+	created  time.Time
+	remember bool
+	vals     map[string]interface{}
+	dirty    map[string]bool
+	w        http.ResponseWriter
+	err      error
+	mu       sync.Mutex
+}
+
+// error from the last attempt to store the session, ie. ErrCookieTooLarge
+func (self *Session) Err() error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	return self.err
 }
 
 func (self *Session) SetFlash(style, key, val string) {
-	self.vals["flash-"+key] = []string{style, val}
-	self.store.Update(self.sid)
+	self.SetValue("flash-"+key, []string{style, val})
 }
 
 func (self *Session) GetFlash(key string) []string {
-	flash := make([]string, 2)
-	if vals, ok := self.vals["flash-"+key]; ok {
-		flash = vals
-		delete(self.vals, "flash-"+key)
-		self.store.Update(self.sid)
-	} else {
-		flash = nil
+	flash, ok := Get[[]string](self, "flash-"+key)
+	if !ok {
+		return nil
 	}
+	self.Del("flash-" + key)
 	return flash
 }
 
 func (self *Session) Has(key string) bool {
+	self.mu.Lock()
 	_, ok := self.vals[key]
-	self.store.Update(self.sid)
+	self.mu.Unlock()
+	self.store.touch(self)
 	return ok
 }
 
 func (self *Session) Set(key string, vals []string) {
-	self.vals[key] = vals
-	self.store.Update(self.sid)
+	self.SetValue(key, vals)
 }
 
 func (self *Session) Get(key string) []string {
-	if _, ok := self.vals[key]; ok {
-		self.store.Update(self.sid)
-		return self.vals[key]
-	}
-	return nil
+	vals, _ := Get[[]string](self, key)
+	return vals
 }
 
 func (self *Session) Del(key string) {
-	delete(self.vals, key)
-	self.store.Update(self.sid)
+	self.mu.Lock()
+	_, ok := self.vals[key]
+	if ok {
+		delete(self.vals, key)
+		self.changed(key)
+	}
+	self.mu.Unlock()
+	if ok {
+		self.store.save(self)
+	}
 }
 
 func (self *Session) Id() string {
+	self.mu.Lock()
 	id := self.sid
-	self.store.Update(self.sid)
+	self.mu.Unlock()
+	self.store.touch(self)
 	return id
 }
 
@@ -0,0 +1,131 @@
+// ---------------
+// session_test.go ::: session store tests
+// ---------------
+// Copyright (c) 2013-Present, Scott Cagno. All rights reserved.
+// This source code is governed by a BSD-style license.
+
+package sess
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// every kind of backend, the key-value one served from memory
+func backends(t *testing.T) map[string]SessionBackend {
+	files, err := NewFileBackend(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go NewKVServer(NewMemoryBackend()).Serve(l)
+	return map[string]SessionBackend{
+		"memory": NewMemoryBackend(),
+		"file":   files,
+		"kv":     NewKVBackend(l.Addr().String(), time.Second),
+	}
+}
+
+// request carrying a session cookie, none if c is nil
+func request(c *http.Cookie) *http.Request {
+	r := httptest.NewRequest("GET", "/", nil)
+	if c != nil {
+		r.AddCookie(c)
+	}
+	return r
+}
+
+// start a session holding user, returning its cookie
+func login(t *testing.T, store *Store) *http.Cookie {
+	w := httptest.NewRecorder()
+	store.GetSession(w, request(nil)).SetValue("user", "alice")
+	for _, c := range w.Result().Cookies() {
+		if c.Name == "sid" {
+			return c
+		}
+	}
+	t.Fatal("no session cookie set")
+	return nil
+}
+
+// a request holding its session while another one writes to it must not
+// undo that write
+func TestConcurrentWrites(t *testing.T) {
+	for name, backend := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			store := NewBackendStore("sid", HOUR, backend)
+			defer store.Stop()
+			c := login(t, store)
+			a := store.GetSession(httptest.NewRecorder(), request(c))
+			store.GetSession(httptest.NewRecorder(), request(c)).SetValue("cart", "book")
+			a.GetString("user")
+			a.SetValue("seen", true)
+			if err := a.Err(); err != nil {
+				t.Fatal(err)
+			}
+			s := store.GetSession(httptest.NewRecorder(), request(c))
+			if s.GetString("user") != "alice" || s.GetString("cart") != "book" || !s.GetBool("seen") {
+				t.Fatalf("lost a write, got user %q cart %q seen %v", s.GetString("user"), s.GetString("cart"), s.GetBool("seen"))
+			}
+		})
+	}
+}
+
+// a request still holding a session must not bring it back after logout
+func TestSaveAfterDelete(t *testing.T) {
+	for name, backend := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			store := NewBackendStore("sid", HOUR, backend)
+			defer store.Stop()
+			c := login(t, store)
+			held := store.GetSession(httptest.NewRecorder(), request(c))
+			store.DelSession(httptest.NewRecorder(), request(c))
+			held.SetFlash("info", "saved", "done")
+			if err := held.Err(); err != ErrNoSession {
+				t.Fatalf("expected ErrNoSession, got %v", err)
+			}
+			s := store.GetSession(httptest.NewRecorder(), request(c))
+			if s.Id() == held.Id() || s.GetString("user") != "" {
+				t.Fatal("deleted session was re-created")
+			}
+		})
+	}
+}
+
+// a request still holding the old id after Regenerate must neither bring
+// it back nor overwrite its grace record
+func TestRegenerateStaleWrite(t *testing.T) {
+	for _, grace := range []time.Duration{0, time.Minute} {
+		for name, backend := range backends(t) {
+			t.Run(name+"/grace="+grace.String(), func(t *testing.T) {
+				store := NewBackendStore("sid", HOUR, backend)
+				defer store.Stop()
+				store.Grace = grace
+				c := login(t, store)
+				held := store.GetSession(httptest.NewRecorder(), request(c))
+				old := held.Id()
+				fresh := store.Regenerate(httptest.NewRecorder(), request(c))
+				held.SetValue("user", "mallory")
+				if _, shared := backend.(*MemoryBackend); !shared && held.Err() != ErrNoSession {
+					t.Fatalf("expected ErrNoSession, got %v", held.Err())
+				}
+				s := store.GetSession(httptest.NewRecorder(), request(c))
+				switch {
+				case s.Id() == old:
+					t.Fatal("old session id came back")
+				case grace > 0 && s.Id() != fresh.Id():
+					t.Fatal("old id no longer resolves to the new session")
+				case grace == 0 && s.GetString("user") != "":
+					t.Fatal("old id still carries the session")
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,160 @@
+// -----------
+// snapshot.go ::: memory session persistence
+// -----------
+// Copyright (c) 2013-Present, Scott Cagno. All rights reserved.
+// This source code is governed by a BSD-style license.
+
+package sess
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// snapshot file header, followed by a version byte and the gob payload
+const (
+	SNAPSHOT_MAGIC   = "NKSESS"
+	SNAPSHOT_VERSION = 1
+)
+
+var (
+	ErrNotMemory   = errors.New("sess: snapshots need the memory backend")
+	ErrBadSnapshot = errors.New("sess: not a session snapshot")
+)
+
+// snapshot payload, version 1
+type snapshotV1 struct {
+	Saved    time.Time
+	Sessions []snapshotEntry
+}
+
+type snapshotEntry struct {
+	Sid     string
+	Data    []byte
+	Expires time.Time
+}
+
+// write every unexpired session to path, through a temporary file renamed
+// into place so a crash never leaves a partial snapshot
+func (self *MemoryBackend) WriteSnapshot(path string) error {
+	now := time.Now()
+	snap := snapshotV1{Saved: now}
+	self.mu.RLock()
+	for _, e := range self.entries {
+		if e.expires.After(now) {
+			snap.Sessions = append(snap.Sessions, snapshotEntry{e.sid, e.data, e.expires})
+		}
+	}
+	self.mu.RUnlock()
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	w := bufio.NewWriter(f)
+	w.WriteString(SNAPSHOT_MAGIC)
+	w.WriteByte(SNAPSHOT_VERSION)
+	if err = gob.NewEncoder(w).Encode(snap); err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// load the sessions of a snapshot, discarding expired ones. a missing file
+// loads nothing, a newer or unknown format is an error and left untouched
+func (self *MemoryBackend) ReadSnapshot(path string) (int, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	head := make([]byte, len(SNAPSHOT_MAGIC)+1)
+	if _, err := io.ReadFull(r, head); err != nil || string(head[:len(SNAPSHOT_MAGIC)]) != SNAPSHOT_MAGIC {
+		return 0, ErrBadSnapshot
+	}
+	var snap snapshotV1
+	switch version := head[len(SNAPSHOT_MAGIC)]; version {
+	case 1:
+		if err := gob.NewDecoder(r).Decode(&snap); err != nil {
+			return 0, err
+		}
+	default:
+		return 0, fmt.Errorf("sess: unsupported snapshot version %d", version)
+	}
+	now, n := time.Now(), 0
+	for _, e := range snap.Sessions {
+		if e.Expires.After(now) {
+			self.Save(e.Sid, e.Data, e.Expires)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// persist the store's memory backend to path, loading it now and writing
+// it every interval and when the store is stopped. a store persists to
+// one path only
+func (self *Store) Persist(path string, interval time.Duration) error {
+	mb, ok := self.backend.(*MemoryBackend)
+	if !ok {
+		return ErrNotMemory
+	}
+	if interval <= 0 {
+		return errors.New("sess: snapshot interval must be positive")
+	}
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if self.stop == nil {
+		return errors.New("sess: store stopped")
+	}
+	if self.snapshot != "" {
+		return errors.New("sess: store already persists to " + self.snapshot)
+	}
+	if _, err := mb.ReadSnapshot(path); err != nil {
+		return err
+	}
+	self.snapshot = path
+	go func(stop chan struct{}) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				self.writeSnapshot()
+			case <-stop:
+				return
+			}
+		}
+	}(self.stop)
+	return nil
+}
+
+// write a snapshot, logging failures
+func (self *Store) writeSnapshot() {
+	if err := self.backend.(*MemoryBackend).WriteSnapshot(self.snapshot); err != nil {
+		log.Printf("sess: snapshot: %v\n", err)
+	}
+}
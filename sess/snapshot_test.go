@@ -0,0 +1,79 @@
+// ----------------
+// snapshot_test.go ::: memory session persistence tests
+// ----------------
+// Copyright (c) 2013-Present, Scott Cagno. All rights reserved.
+// This source code is governed by a BSD-style license.
+
+package sess
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// unexpired sessions survive a write and read, expired ones are dropped
+func TestSnapshotRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions")
+	mb := NewMemoryBackend()
+	mb.Save("live", []byte("alice"), time.Now().Add(time.Hour))
+	mb.Save("gone", []byte("bob"), time.Now().Add(-time.Second))
+	if err := mb.WriteSnapshot(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewMemoryBackend()
+	n, err := loaded.ReadSnapshot(path)
+	if err != nil || n != 1 {
+		t.Fatalf("expected 1 session, got %d %v", n, err)
+	}
+	if data, err := loaded.Load("live"); err != nil || string(data) != "alice" {
+		t.Fatalf("expected alice, got %q %v", data, err)
+	}
+	if _, err := loaded.Load("gone"); err != ErrNoSession {
+		t.Fatal("expired session was loaded")
+	}
+	if n, err := loaded.ReadSnapshot(filepath.Join(t.TempDir(), "missing")); n != 0 || err != nil {
+		t.Fatalf("missing snapshot: %d %v", n, err)
+	}
+}
+
+// unknown versions and foreign files are refused and left in place
+func TestSnapshotRefused(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"version": SNAPSHOT_MAGIC + "\x02payload",
+		"magic":   "GOBSES\x01payload",
+		"short":   "NK",
+	} {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, []byte(content), 0600)
+		if n, err := NewMemoryBackend().ReadSnapshot(path); err == nil || n != 0 {
+			t.Fatalf("%s: expected an error, got %d", name, n)
+		}
+		if b, _ := os.ReadFile(path); string(b) != content {
+			t.Fatalf("%s: snapshot was modified", name)
+		}
+	}
+}
+
+// persist refuses a bad interval and a second path, and writes on stop
+func TestPersist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions")
+	store := NewSessionStore("sid", HOUR)
+	defer store.Stop()
+	if err := store.Persist(path, 0); err == nil {
+		t.Fatal("zero interval accepted")
+	}
+	if err := store.Persist(path, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Persist(path+".2", time.Hour); err == nil {
+		t.Fatal("second path accepted")
+	}
+	login(t, store)
+	store.Stop()
+	if n, err := NewMemoryBackend().ReadSnapshot(path); err != nil || n != 1 {
+		t.Fatalf("expected 1 session on stop, got %d %v", n, err)
+	}
+}
@@ -0,0 +1,273 @@
+// ---------
+// values.go ::: typed session values and serialization
+// ---------
+// Copyright (c) 2013-Present, Scott Cagno. All rights reserved.
+// This source code is governed by a BSD-style license.
+
+package sess
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"time"
+)
+
+// encodes sessions and their values for backends and cookies
+type Serializer interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(b []byte, v interface{}) error
+}
+
+var (
+	// compact and readable, numbers decode into whatever type is asked for
+	JSON Serializer = jsonSerializer{}
+	// keeps go types exactly, values must be gob encodable
+	Gob Serializer = gobSerializer{}
+)
+
+type jsonSerializer struct{}
+
+func (jsonSerializer) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonSerializer) Unmarshal(b []byte, v interface{}) error {
+	return json.Unmarshal(b, v)
+}
+
+type gobSerializer struct{}
+
+func (gobSerializer) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+func (gobSerializer) Unmarshal(b []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(b)).Decode(v)
+}
+
+// a serialized value not yet decoded, values are only decoded once asked
+// for since only then is their type known
+type rawValue []byte
+
+func (self rawValue) MarshalJSON() ([]byte, error) {
+	return self, nil
+}
+
+func (self *rawValue) UnmarshalJSON(b []byte) error {
+	*self = append((*self)[:0], b...)
+	return nil
+}
+
+func (self rawValue) GobEncode() ([]byte, error) {
+	return self, nil
+}
+
+func (self *rawValue) GobDecode(b []byte) error {
+	*self = append((*self)[:0], b...)
+	return nil
+}
+
+// encoded session
+type record struct {
+	Sid      string              `json:"sid"`
+	Ts       time.Time           `json:"ts"`
+	Created  time.Time           `json:"created"`
+	Remember bool                `json:"remember,omitempty"`
+	Vals     map[string]rawValue `json:"vals"`
+	Moved    string              `json:"moved,omitempty"`
+}
+
+// serializer in use, JSON unless set
+func (self *Store) serializer() Serializer {
+	if self.Serializer == nil {
+		return JSON
+	}
+	return self.Serializer
+}
+
+// encode a session for storage, the caller holds the session lock
+func (self *Store) encode(session *Session) ([]byte, error) {
+	ser := self.serializer()
+	rec := record{
+		Sid:      session.sid,
+		Ts:       session.ts,
+		Created:  session.created,
+		Remember: session.remember,
+		Vals:     make(map[string]rawValue, len(session.vals)),
+	}
+	for key, v := range session.vals {
+		raw, err := self.marshalValue(v)
+		if err != nil {
+			return nil, err
+		}
+		rec.Vals[key] = raw
+	}
+	return ser.Marshal(rec)
+}
+
+// encode a single value, raw values are kept as they are
+func (self *Store) marshalValue(v interface{}) (rawValue, error) {
+	if raw, ok := v.(rawValue); ok {
+		return raw, nil
+	}
+	return self.serializer().Marshal(v)
+}
+
+// merge the keys a session changed into its stored record and save it, so
+// concurrent requests on one session keep each other's writes. saves of a
+// sid are serialised, the caller holds the session lock. a session no
+// longer stored, or moved to a new id, is not written
+func (self *Store) merge(session *Session, expires time.Time) error {
+	mu := self.lock(session.sid)
+	mu.Lock()
+	defer mu.Unlock()
+	b, err := self.backend.Load(session.sid)
+	if err != nil {
+		return err
+	}
+	rec, err := self.decode(b)
+	if err != nil {
+		return err
+	}
+	if rec.Moved != "" {
+		return ErrNoSession
+	}
+	if rec.Vals == nil {
+		rec.Vals = make(map[string]rawValue)
+	}
+	for key := range session.dirty {
+		v, ok := session.vals[key]
+		if !ok {
+			delete(rec.Vals, key)
+			continue
+		}
+		raw, err := self.marshalValue(v)
+		if err != nil {
+			return err
+		}
+		rec.Vals[key] = raw
+	}
+	// take in what other requests stored meanwhile
+	for key := range session.vals {
+		if _, ok := rec.Vals[key]; !ok && !session.dirty[key] {
+			delete(session.vals, key)
+		}
+	}
+	for key, raw := range rec.Vals {
+		if _, ok := session.vals[key]; !ok {
+			session.vals[key] = raw
+		}
+	}
+	rec.Sid, rec.Ts, rec.Created, rec.Remember = session.sid, session.ts, session.created, session.remember
+	if b, err = self.serializer().Marshal(rec); err != nil {
+		return err
+	}
+	return self.backend.Save(session.sid, b, expires)
+}
+
+// decode a stored session
+func (self *Store) decode(b []byte) (record, error) {
+	var rec record
+	err := self.serializer().Unmarshal(b, &rec)
+	return rec, err
+}
+
+// session from a decoded record, values stay raw until asked for
+func (rec record) session(store *Store) *Session {
+	vals := make(map[string]interface{}, len(rec.Vals))
+	for key, raw := range rec.Vals {
+		vals[key] = raw
+	}
+	return &Session{
+		sid:      rec.Sid,
+		store:    store,
+		ts:       rec.Ts,
+		created:  rec.Created,
+		remember: rec.Remember,
+		vals:     vals,
+	}
+}
+
+// store any serializable value
+func (self *Session) SetValue(key string, v interface{}) {
+	self.mu.Lock()
+	self.vals[key] = v
+	self.changed(key)
+	self.mu.Unlock()
+	self.store.save(self)
+}
+
+// note a key set or deleted since the last save, the caller holds the
+// session lock
+func (self *Session) changed(key string) {
+	if self.dirty == nil {
+		self.dirty = make(map[string]bool)
+	}
+	self.dirty[key] = true
+}
+
+// return a value as type T, converting it through the store's serializer
+// if it was stored as another type or loaded from a backend
+func Get[T any](s *Session, key string) (T, bool) {
+	var zero T
+	s.mu.Lock()
+	v, ok := s.vals[key]
+	s.mu.Unlock()
+	if !ok {
+		return zero, false
+	}
+	if t, ok := v.(T); ok {
+		s.store.touch(s)
+		return t, true
+	}
+	ser := s.store.serializer()
+	raw, isRaw := v.(rawValue)
+	if !isRaw {
+		b, err := ser.Marshal(v)
+		if err != nil {
+			return zero, false
+		}
+		raw = b
+	}
+	var t T
+	if err := ser.Unmarshal(raw, &t); err != nil {
+		return zero, false
+	}
+	if isRaw {
+		// keep the decoded value unless it was replaced meanwhile
+		s.mu.Lock()
+		if _, ok := s.vals[key].(rawValue); ok {
+			s.vals[key] = t
+		}
+		s.mu.Unlock()
+	}
+	s.store.touch(s)
+	return t, true
+}
+
+// return a string value, or ""
+func (self *Session) GetString(key string) string {
+	v, _ := Get[string](self, key)
+	return v
+}
+
+// return an int value, or 0
+func (self *Session) GetInt(key string) int {
+	v, _ := Get[int](self, key)
+	return v
+}
+
+// return a bool value, or false
+func (self *Session) GetBool(key string) bool {
+	v, _ := Get[bool](self, key)
+	return v
+}
+
+// return a time value, or the zero time
+func (self *Session) GetTime(key string) time.Time {
+	v, _ := Get[time.Time](self, key)
+	return v
+}
@@ -0,0 +1,160 @@
+// ---------
+// render.go ::: typed response renderers and content negotiation
+// ---------
+// Copyright (c) 2013-Present, Scott Cagno. All rights reserved.
+// This source code is governed by a BSD-style license.
+
+package tmpl
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// content types
+const (
+	MimeHTML = "text/html; charset=utf-8"
+	MimeJSON = "application/json; charset=utf-8"
+	MimeXML  = "application/xml; charset=utf-8"
+	MimeText = "text/plain; charset=utf-8"
+)
+
+var ErrNotAcceptable = errors.New("tmpl: no acceptable representation")
+
+// write a complete, already encoded body
+func write(w http.ResponseWriter, status int, typ string, b []byte) error {
+	hdr := w.Header()
+	if typ != "" {
+		hdr.Set("Content-Type", typ)
+	}
+	hdr.Set("Content-Length", strconv.Itoa(len(b)))
+	w.WriteHeader(status)
+	_, err := w.Write(b)
+	return err
+}
+
+// render v as json, nothing is written if encoding fails
+func (self *TemplateStore) JSON(w http.ResponseWriter, status int, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return write(w, status, MimeJSON, b)
+}
+
+// render v as xml, nothing is written if encoding fails
+func (self *TemplateStore) XML(w http.ResponseWriter, status int, v interface{}) error {
+	b, err := xml.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return write(w, status, MimeXML, append([]byte(xml.Header), b...))
+}
+
+// render a cached template, nothing is written if execution fails
+func (self *TemplateStore) HTML(w http.ResponseWriter, r *http.Request, status int, name string, m interface{}) error {
+	t, err := self.lookup(r, name)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, m); err != nil {
+		return err
+	}
+	return write(w, status, MimeHTML, buf.Bytes())
+}
+
+// render formatted plain text
+func (self *TemplateStore) Text(w http.ResponseWriter, status int, format string, a ...interface{}) error {
+	return write(w, status, MimeText, []byte(fmt.Sprintf(format, a...)))
+}
+
+// render raw bytes with the given content type
+func (self *TemplateStore) Blob(w http.ResponseWriter, status int, typ string, b []byte) error {
+	return write(w, status, typ, b)
+}
+
+// redirect to url, status must be a 3xx code
+func (self *TemplateStore) Redirect(w http.ResponseWriter, r *http.Request, status int, url string) error {
+	if status < 300 || status > 308 {
+		return fmt.Errorf("tmpl: invalid redirect status %d", status)
+	}
+	http.Redirect(w, r, url, status)
+	return nil
+}
+
+// respond with 204 and no body
+func (self *TemplateStore) NoContent(w http.ResponseWriter) error {
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// render v in the format preferred by the accept header, html uses the
+// named template and is only offered when name is not empty
+func (self *TemplateStore) Negotiate(w http.ResponseWriter, r *http.Request, status int, name string, v interface{}) error {
+	offers := []string{"application/json", "application/xml", "text/xml", "text/plain"}
+	if name != "" {
+		offers = append([]string{"text/html"}, offers...)
+	}
+	w.Header().Add("Vary", "Accept")
+	switch Accept(r, offers...) {
+	case "text/html":
+		return self.HTML(w, r, status, name, v)
+	case "application/json":
+		return self.JSON(w, status, v)
+	case "application/xml", "text/xml":
+		return self.XML(w, status, v)
+	case "text/plain":
+		return self.Text(w, status, "%v", v)
+	}
+	http.Error(w, "Not Acceptable", http.StatusNotAcceptable)
+	return ErrNotAcceptable
+}
+
+// return the offer best matching the accept header, or "" if none match
+func Accept(r *http.Request, offers ...string) string {
+	header := r.Header.Get("Accept")
+	if header == "" && len(offers) > 0 {
+		return offers[0]
+	}
+	type mediaRange struct {
+		typ string
+		q   float64
+	}
+	var ranges []mediaRange
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		mr := mediaRange{strings.ToLower(strings.TrimSpace(params[0])), 1}
+		for _, p := range params[1:] {
+			if k, v, ok := strings.Cut(strings.TrimSpace(p), "="); ok && k == "q" {
+				if q, err := strconv.ParseFloat(v, 64); err == nil {
+					mr.q = q
+				}
+			}
+		}
+		if mr.typ != "" && mr.q > 0 {
+			ranges = append(ranges, mr)
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].q > ranges[j].q
+	})
+	for _, mr := range ranges {
+		for _, offer := range offers {
+			switch {
+			case mr.typ == offer, mr.typ == "*/*":
+				return offer
+			case strings.HasSuffix(mr.typ, "/*") && strings.HasPrefix(offer, mr.typ[:len(mr.typ)-1]):
+				return offer
+			}
+		}
+	}
+	return ""
+}
@@ -24,16 +24,23 @@ type TemplateStore struct {
 	dir    string
 	base   string
 	cached map[string]*template.Template
+	master map[string]*template.Template
 	funcs  template.FuncMap
+	reqfns map[string]RequestFunc
 	mu     sync.Mutex
 }
 
+// template function evaluated against the current request
+type RequestFunc func(r *http.Request) interface{}
+
 // return a new template store instace
 func NewTemplateStore(dir, base string) *TemplateStore {
 	return &TemplateStore{
 		dir:    dir,
 		base:   base,
 		cached: make(map[string]*template.Template),
+		master: make(map[string]*template.Template),
+		reqfns: make(map[string]RequestFunc),
 		funcs: template.FuncMap{
 			"title"	: 	strings.Title,
 			"safe"	: 	safe,
@@ -106,14 +113,59 @@ func (self *TemplateStore) Load(name ...string) {
 		t := template.New(self.base).Funcs(self.funcs)
 		t = template.Must(t.ParseFiles(self.dir+"/"+self.base, self.dir+"/"+name[i]))
 		self.cached[name[i]] = t
+		self.master[name[i]] = template.Must(t.Clone())
 	}
 }
 
+// register a request scoped template function, must be called before Load
+func (self *TemplateStore) RequestFunc(name string, fn RequestFunc) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	self.reqfns[name] = fn
+	self.funcs[name] = func() string { return "" }
+}
+
 // render a template by name
 func (self *TemplateStore) Render(w http.ResponseWriter, name string, m interface{}) {
 	self.cached[name].Execute(w, m)
 }
 
+// render a template by name, binding request scoped functions to r
+func (self *TemplateStore) RenderRequest(w http.ResponseWriter, r *http.Request, name string, m interface{}) {
+	t, err := self.lookup(r, name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	t.Execute(w, m)
+}
+
+// return a cached template, cloned with request scoped functions bound to r
+func (self *TemplateStore) lookup(r *http.Request, name string) (*template.Template, error) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if r == nil || len(self.reqfns) == 0 {
+		if t, ok := self.cached[name]; ok {
+			return t, nil
+		}
+		return nil, fmt.Errorf("tmpl: template %q not loaded", name)
+	}
+	master, ok := self.master[name]
+	if !ok {
+		return nil, fmt.Errorf("tmpl: template %q not loaded", name)
+	}
+	t, err := master.Clone()
+	if err != nil {
+		return nil, err
+	}
+	funcs := make(template.FuncMap, len(self.reqfns))
+	for n, fn := range self.reqfns {
+		fn := fn
+		funcs[n] = func() interface{} { return fn(r) }
+	}
+	return t.Funcs(funcs), nil
+}
+
 // render raw data
 func (self *TemplateStore) Raw(w http.ResponseWriter, format string, a ...interface{}) {
 	fmt.Fprintf(w, format, a...)
@@ -121,7 +173,7 @@ func (self *TemplateStore) Raw(w http.ResponseWriter, format string, a ...interf
 
 // set the header content type
 func (self *TemplateStore) ContentType(w http.ResponseWriter, typ string) {
-	w.Header().Set("Content Type", typ)
+	w.Header().Set("Content-Type", typ)
 }
 
 // simple form validater
@@ -0,0 +1,154 @@
+// --------
+// trace.go ::: request ids and w3c trace context
+// --------
+// Copyright (c) 2013-Present, Scott Cagno. All rights reserved.
+// This source code is governed by a BSD-style license.
+
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+const (
+	REQUEST_ID  = "X-Request-ID"
+	TRACEPARENT = "traceparent"
+	TRACESTATE  = "tracestate"
+)
+
+type ctxKey struct{}
+
+// request id and trace context for a single request
+type Trace struct {
+	RequestId string
+	TraceId   string
+	ParentId  string
+	SpanId    string
+	Flags     string
+	State     string
+}
+
+// middleware assigning or accepting a request id and trace context
+func Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t := FromRequest(r)
+		w.Header().Set(REQUEST_ID, t.RequestId)
+		h.ServeHTTP(w, r.WithContext(NewContext(r.Context(), t)))
+	})
+}
+
+// build a trace from incoming headers, generating whatever is missing
+func FromRequest(r *http.Request) *Trace {
+	t := &Trace{
+		RequestId: r.Header.Get(REQUEST_ID),
+		SpanId:    random(8),
+		Flags:     "00",
+	}
+	if !validId(t.RequestId) {
+		t.RequestId = random(16)
+	}
+	if traceId, parentId, flags, ok := parseTraceparent(r.Header.Get(TRACEPARENT)); ok {
+		t.TraceId, t.ParentId, t.Flags = traceId, parentId, flags
+		t.State = r.Header.Get(TRACESTATE)
+	} else {
+		t.TraceId = random(16)
+	}
+	return t
+}
+
+// return the traceparent header value naming this span as parent
+func (self *Trace) Traceparent() string {
+	return "00-" + self.TraceId + "-" + self.SpanId + "-" + self.Flags
+}
+
+// return a context carrying the trace
+func NewContext(ctx context.Context, t *Trace) context.Context {
+	return context.WithValue(ctx, ctxKey{}, t)
+}
+
+// return the trace carried by a context, or nil
+func FromContext(ctx context.Context) *Trace {
+	if ctx == nil {
+		return nil
+	}
+	t, _ := ctx.Value(ctxKey{}).(*Trace)
+	return t
+}
+
+// set request id and trace headers on an outbound request
+func Inject(ctx context.Context, hdr http.Header) {
+	t := FromContext(ctx)
+	if t == nil {
+		return
+	}
+	hdr.Set(REQUEST_ID, t.RequestId)
+	hdr.Set(TRACEPARENT, t.Traceparent())
+	if t.State != "" {
+		hdr.Set(TRACESTATE, t.State)
+	}
+}
+
+// return a log prefix identifying the request, or "" without a trace
+func Prefix(ctx context.Context) string {
+	t := FromContext(ctx)
+	if t == nil {
+		return ""
+	}
+	return "[req=" + t.RequestId + " trace=" + t.TraceId + "] "
+}
+
+// parse a version 00 traceparent header
+func parseTraceparent(s string) (traceId, parentId, flags string, ok bool) {
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return "", "", "", false
+	}
+	if parts[0] == "00" && len(parts) != 4 {
+		return "", "", "", false
+	}
+	traceId, parentId, flags = parts[1], parts[2], parts[3]
+	if !isHex(parts[0]) || !isHex(traceId) || len(traceId) != 32 || !isHex(parentId) || len(parentId) != 16 ||
+		!isHex(flags) || len(flags) != 2 || allZero(traceId) || allZero(parentId) {
+		return "", "", "", false
+	}
+	return traceId, parentId, flags, true
+}
+
+// test for lowercase hex
+func isHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !('0' <= s[i] && s[i] <= '9' || 'a' <= s[i] && s[i] <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// test for an all zero id
+func allZero(s string) bool {
+	return strings.Trim(s, "0") == ""
+}
+
+// test whether an incoming request id is safe to reuse
+func validId(s string) bool {
+	if s == "" || len(s) > 128 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x21 || s[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// random lowercase hex string of n bytes
+func random(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,307 @@
+// -------
+// bind.go ::: request binding and validation
+// -------
+// Copyright (c) 2013-Present, Scott Cagno. All rights reserved.
+// This source code is governed by a BSD-style license.
+
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/mail"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/scottcagno/net_kit/frms"
+)
+
+// key used for errors not tied to a single field
+const BODY = "body"
+
+// field keyed binding and validation errors
+type BindErrors map[string]string
+
+// implement error
+func (self BindErrors) Error() string {
+	keys := make([]string, 0, len(self))
+	for k := range self {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	msgs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		msgs = append(msgs, k+": "+self[k])
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// set each error on the matching form input
+func (self BindErrors) Apply(form *frms.Form) {
+	if form.Errors == nil {
+		form.Errors = make(map[string]string)
+	}
+	for k, v := range self {
+		form.SetError(k, v)
+	}
+}
+
+// decode path params, query, form and json bodies into the struct pointed
+// to by v using the path, query, form and json field tags, then validate it
+// using valid tags. a non nil error is always of type BindErrors
+func Bind(r *http.Request, v interface{}) error {
+	val := reflect.ValueOf(v)
+	if val.Kind() != reflect.Ptr || val.Elem().Kind() != reflect.Struct {
+		return BindErrors{BODY: fmt.Sprintf("expected pointer to struct, got %T", v)}
+	}
+	errs := make(BindErrors)
+	typ, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch {
+	case typ == "application/json" && r.Body != nil:
+		if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+			errs[BODY] = "malformed json: " + err.Error()
+			return errs
+		}
+	case typ == "multipart/form-data":
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			errs[BODY] = err.Error()
+			return errs
+		}
+	default:
+		if err := r.ParseForm(); err != nil {
+			errs[BODY] = err.Error()
+			return errs
+		}
+	}
+	bindStruct(r, val.Elem(), errs)
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// bind and validate the fields of a struct value
+func bindStruct(r *http.Request, val reflect.Value, errs BindErrors) {
+	typ := val.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		fld := typ.Field(i)
+		if fld.Anonymous && fld.Type.Kind() == reflect.Struct {
+			bindStruct(r, val.Field(i), errs)
+			continue
+		}
+		if fld.PkgPath != "" {
+			continue
+		}
+		name := fieldName(fld)
+		var vals []string
+		switch {
+		case fld.Tag.Get("path") != "":
+			if s := Param(r, fld.Tag.Get("path")); s != "" {
+				vals = []string{s}
+			}
+		case fld.Tag.Get("query") != "":
+			vals = r.URL.Query()[fld.Tag.Get("query")]
+		case fld.Tag.Get("form") != "":
+			vals = r.PostForm[fld.Tag.Get("form")]
+		}
+		if len(vals) > 0 {
+			if err := setValues(val.Field(i), vals); err != nil {
+				errs[name] = fmt.Sprintf("*%s %s", title(name), err)
+				continue
+			}
+		}
+		if rules := fld.Tag.Get("valid"); rules != "" {
+			if msg := validate(val.Field(i), rules); msg != "" {
+				errs[name] = fmt.Sprintf("*%s %s", title(name), msg)
+			}
+		}
+	}
+}
+
+// return the name a field is reported under
+func fieldName(fld reflect.StructField) string {
+	for _, tag := range []string{"form", "query", "path", "json"} {
+		if name := strings.Split(fld.Tag.Get(tag), ",")[0]; name != "" && name != "-" {
+			return name
+		}
+	}
+	return fld.Name
+}
+
+// title case a field name for messages
+func title(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+// set a field from one or more string values
+func setValues(v reflect.Value, vals []string) error {
+	if v.Kind() == reflect.Slice && v.Type().Elem().Kind() != reflect.Uint8 {
+		s := reflect.MakeSlice(v.Type(), len(vals), len(vals))
+		for i, val := range vals {
+			if err := setValue(s.Index(i), val); err != nil {
+				return err
+			}
+		}
+		v.Set(s)
+		return nil
+	}
+	return setValue(v, vals[0])
+}
+
+// set a single value from its string form
+func setValue(v reflect.Value, s string) error {
+	switch v.Interface().(type) {
+	case time.Time:
+		for _, layout := range []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02"} {
+			if t, err := time.Parse(layout, s); err == nil {
+				v.Set(reflect.ValueOf(t))
+				return nil
+			}
+		}
+		return fmt.Errorf("requires a date")
+	case time.Duration:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("requires a duration")
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	if v.Kind() == reflect.Ptr {
+		p := reflect.New(v.Type().Elem())
+		if err := setValue(p.Elem(), s); err != nil {
+			return err
+		}
+		v.Set(p)
+		return nil
+	}
+	if err := setString(v, s); err != nil {
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+			return fmt.Errorf("requires a number")
+		case reflect.Bool:
+			return fmt.Errorf("requires true or false")
+		}
+		return err
+	}
+	return nil
+}
+
+// compiled regexp rules, shared by every request
+var patterns sync.Map
+
+// return a compiled regexp rule
+func pattern(expr string) (*regexp.Regexp, error) {
+	if re, ok := patterns.Load(expr); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	patterns.Store(expr, re)
+	return re, nil
+}
+
+// split comma separated rules, a regexp rule takes the rest of the tag so
+// its pattern may hold commas, ie. "required,regexp=^[a-z]{2,5}$"
+func splitRules(rules string) []string {
+	var list []string
+	for rules != "" {
+		rule, rest, _ := strings.Cut(rules, ",")
+		if strings.HasPrefix(strings.TrimSpace(rule), "regexp=") {
+			return append(list, strings.TrimSpace(rules))
+		}
+		list = append(list, rule)
+		rules = rest
+	}
+	return list
+}
+
+// check a value against comma separated rules, return a message on failure
+func validate(v reflect.Value, rules string) string {
+	for _, rule := range splitRules(rules) {
+		key, arg, _ := strings.Cut(strings.TrimSpace(rule), "=")
+		switch key {
+		case "required":
+			if v.IsZero() {
+				return "is a required field"
+			}
+		case "email":
+			if s := v.String(); s != "" {
+				if _, err := mail.ParseAddress(s); err != nil || strings.Contains(s, "<") {
+					return "requires an email address"
+				}
+			}
+		case "min", "max", "len":
+			n, err := strconv.ParseFloat(arg, 64)
+			if err != nil {
+				return "has an invalid " + key + " rule"
+			}
+			if msg := checkSize(v, key, n); msg != "" {
+				return msg
+			}
+		case "oneof":
+			s := fmt.Sprint(v.Interface())
+			ok := false
+			for _, opt := range strings.Fields(arg) {
+				ok = ok || s == opt
+			}
+			if !ok {
+				return "must be one of " + strings.Join(strings.Fields(arg), ", ")
+			}
+		case "regexp":
+			re, err := pattern(arg)
+			if err != nil {
+				return "has an invalid regexp rule"
+			}
+			if !re.MatchString(v.String()) {
+				return "is not in the expected format"
+			}
+		}
+	}
+	return ""
+}
+
+// check a min, max or len rule, using length for strings and slices
+func checkSize(v reflect.Value, key string, n float64) string {
+	var size float64
+	unit := ""
+	switch v.Kind() {
+	case reflect.String:
+		size, unit = float64(utf8.RuneCountInString(v.String())), " characters"
+	case reflect.Slice, reflect.Map, reflect.Array:
+		size, unit = float64(v.Len()), " items"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		size = float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		size = float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		size = v.Float()
+	default:
+		return ""
+	}
+	num := strconv.FormatFloat(n, 'f', -1, 64)
+	switch {
+	case key == "min" && size < n:
+		return "minimum is " + num + unit
+	case key == "max" && size > n:
+		return "maximum is " + num + unit
+	case key == "len" && size != n:
+		return "must be exactly " + num + unit
+	}
+	return ""
+}
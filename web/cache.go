@@ -0,0 +1,517 @@
+// --------
+// cache.go ::: http response caching
+// --------
+// Copyright (c) 2013-Present, Scott Cagno. All rights reserved.
+// This source code is governed by a BSD-style license.
+
+package web
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// response header listing cache tags, removed before the response is sent
+const CACHE_TAG = "Cache-Tag"
+
+// cache options. DefaultTTL applies to responses with a Cache-Control
+// header giving no lifetime, ie. "public", responses without one are not
+// cached. KeyHeaders are added to the cache key
+type CacheOptions struct {
+	MaxBytes   int64
+	DefaultTTL time.Duration
+	KeyHeaders []string
+}
+
+// cached response
+type cacheEntry struct {
+	key, route string
+	base       string
+	names      []string
+	tags       []string
+	status     int
+	header     http.Header
+	body       []byte
+	etag       string
+	stored     time.Time
+	ttl, swr   time.Duration
+	size       int64
+}
+
+// response caching middleware backed by an lru bounded by bytes
+type Cache struct {
+	opts       CacheOptions
+	ll         *list.List
+	items      map[string]*list.Element
+	vary       map[string]*varyNames
+	refreshing map[string]bool
+	size       int64
+	mu         sync.Mutex
+}
+
+// header names a base key varies on, n counts the entries stored under it
+type varyNames struct {
+	names []string
+	n     int
+}
+
+// return a new response cache
+func NewCache(opts CacheOptions) *Cache {
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = 64 << 20
+	}
+	return &Cache{
+		opts:       opts,
+		ll:         list.New(),
+		items:      make(map[string]*list.Element),
+		vary:       make(map[string]*varyNames),
+		refreshing: make(map[string]bool),
+	}
+}
+
+// wrap a handler (usually the multiplexer), serving cacheable responses
+// from memory
+func (self *Cache) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if (r.Method != "GET" && r.Method != "HEAD") || r.Header.Get("Upgrade") != "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		reqcc := parseCacheControl(r.Header.Get("Cache-Control"))
+		if _, ok := reqcc["no-store"]; ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+		base := self.baseKey(r)
+		if _, ok := reqcc["no-cache"]; !ok {
+			if e, stale := self.lookup(base, r); e != nil {
+				if rt, ok := r.Context().Value(routeKey{}).(*route); ok {
+					rt.pattern = e.route
+				}
+				if stale {
+					self.refresh(h, base, r)
+					w.Header().Set("X-Cache", "STALE")
+				} else {
+					w.Header().Set("X-Cache", "HIT")
+				}
+				e.serve(w, r)
+				return
+			}
+		}
+		r, rt := withRoute(r)
+		cw := &captureWriter{ResponseWriter: w, header: make(http.Header)}
+		h.ServeHTTP(cw, r)
+		if cw.passthrough {
+			return
+		}
+		e := self.entry(base, rt.pattern, r, cw.Status(), cw.header, cw.buf.Bytes())
+		if e == nil {
+			cw.commit()
+			return
+		}
+		self.store(e)
+		w.Header().Set("X-Cache", "MISS")
+		e.serve(w, r)
+	})
+}
+
+// remove every entry served by a route pattern
+func (self *Cache) PurgeRoute(pattern string) int {
+	return self.purge(func(e *cacheEntry) bool { return e.route == pattern })
+}
+
+// remove every entry carrying a tag
+func (self *Cache) PurgeTag(tag string) int {
+	return self.purge(func(e *cacheEntry) bool {
+		for _, t := range e.tags {
+			if t == tag {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// remove every entry
+func (self *Cache) PurgeAll() int {
+	return self.purge(func(e *cacheEntry) bool { return true })
+}
+
+// return the number of bytes held
+func (self *Cache) Size() int64 {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	return self.size
+}
+
+// remove entries matching fn
+func (self *Cache) purge(fn func(e *cacheEntry) bool) int {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	n := 0
+	for el := self.ll.Front(); el != nil; {
+		next := el.Next()
+		if fn(el.Value.(*cacheEntry)) {
+			self.remove(el)
+			n++
+		}
+		el = next
+	}
+	return n
+}
+
+// key on method, path, query and the configured headers
+func (self *Cache) baseKey(r *http.Request) string {
+	var b strings.Builder
+	b.WriteString(r.Method + " " + r.URL.Path + "?" + r.URL.RawQuery)
+	for _, h := range self.opts.KeyHeaders {
+		b.WriteString("\n" + h + ": " + r.Header.Get(h))
+	}
+	return b.String()
+}
+
+// extend the base key with the values of headers the response varies on
+func variantKey(base string, names []string, r *http.Request) string {
+	var b strings.Builder
+	b.WriteString(base)
+	for _, name := range names {
+		b.WriteString("\nvary " + name + ": " + r.Header.Get(name))
+	}
+	return b.String()
+}
+
+// find a fresh or stale-while-revalidate entry
+func (self *Cache) lookup(base string, r *http.Request) (*cacheEntry, bool) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	var names []string
+	if v, ok := self.vary[base]; ok {
+		names = v.names
+	}
+	el, ok := self.items[variantKey(base, names, r)]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*cacheEntry)
+	age := time.Since(e.stored)
+	switch {
+	case age < e.ttl:
+		self.ll.MoveToFront(el)
+		return e, false
+	case age < e.ttl+e.swr:
+		self.ll.MoveToFront(el)
+		return e, true
+	}
+	self.remove(el)
+	return nil, false
+}
+
+// refresh a stale entry in the background, once per key
+func (self *Cache) refresh(h http.Handler, base string, r *http.Request) {
+	self.mu.Lock()
+	if self.refreshing[base] {
+		self.mu.Unlock()
+		return
+	}
+	self.refreshing[base] = true
+	self.mu.Unlock()
+	r = r.Clone(context.Background())
+	r.Header.Del("If-None-Match")
+	go func() {
+		defer func() {
+			self.mu.Lock()
+			delete(self.refreshing, base)
+			self.mu.Unlock()
+		}()
+		r, rt := withRoute(r)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		if e := self.entry(base, rt.pattern, r, rec.Code, rec.Header(), rec.Body.Bytes()); e != nil {
+			self.store(e)
+		}
+	}()
+}
+
+// build an entry from a response, or nil if it may not be cached
+func (self *Cache) entry(base, route string, r *http.Request, status int, hdr http.Header, body []byte) *cacheEntry {
+	switch status {
+	case http.StatusOK, http.StatusNonAuthoritativeInfo, http.StatusMovedPermanently, http.StatusNotFound, http.StatusGone:
+	default:
+		return nil
+	}
+	if hdr.Get("Set-Cookie") != "" {
+		return nil
+	}
+	cc := parseCacheControl(hdr.Get("Cache-Control"))
+	if _, ok := cc["no-store"]; ok {
+		return nil
+	}
+	if _, ok := cc["private"]; ok {
+		return nil
+	}
+	if !self.shared(r, hdr, cc) {
+		return nil
+	}
+	ttl := self.opts.DefaultTTL
+	if len(cc) == 0 {
+		ttl = 0
+	}
+	if v, ok := cc["s-maxage"]; ok {
+		ttl = seconds(v)
+	} else if v, ok := cc["max-age"]; ok {
+		ttl = seconds(v)
+	} else if _, ok := cc["no-cache"]; ok {
+		ttl = 0
+	}
+	swr := seconds(cc["stale-while-revalidate"])
+	if ttl <= 0 && swr <= 0 {
+		return nil
+	}
+	var names []string
+	for _, v := range hdr.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			if name = http.CanonicalHeaderKey(strings.TrimSpace(name)); name == "*" {
+				return nil
+			} else if name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	var tags []string
+	for _, v := range strings.Split(hdr.Get(CACHE_TAG), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			tags = append(tags, v)
+		}
+	}
+	header := hdr.Clone()
+	header.Del(CACHE_TAG)
+	etag := header.Get("ETag")
+	if etag == "" {
+		sum := sha1.Sum(body)
+		etag = `"` + hex.EncodeToString(sum[:]) + `"`
+		header.Set("ETag", etag)
+	}
+	e := &cacheEntry{
+		key:    variantKey(base, names, r),
+		route:  route,
+		base:   base,
+		names:  names,
+		tags:   tags,
+		status: status,
+		header: header,
+		body:   append([]byte(nil), body...),
+		etag:   etag,
+		stored: time.Now(),
+		ttl:    ttl,
+		swr:    swr,
+	}
+	e.size = int64(len(e.key) + len(e.base) + len(e.body))
+	for _, name := range names {
+		e.size += int64(len(name))
+	}
+	for k, vals := range header {
+		for _, v := range vals {
+			e.size += int64(len(k) + len(v))
+		}
+	}
+	return e
+}
+
+// report whether a response may be served to other clients. responses to
+// requests carrying credentials are per user unless marked public, or the
+// credential header is part of the key or varied on
+func (self *Cache) shared(r *http.Request, hdr http.Header, cc map[string]string) bool {
+	if _, ok := cc["public"]; ok {
+		return true
+	}
+	if _, ok := cc["s-maxage"]; ok {
+		return true
+	}
+	for _, name := range []string{"Authorization", "Cookie"} {
+		if r.Header.Get(name) == "" {
+			continue
+		}
+		keyed := false
+		for _, h := range append(self.opts.KeyHeaders, strings.Split(strings.Join(hdr.Values("Vary"), ","), ",")...) {
+			keyed = keyed || http.CanonicalHeaderKey(strings.TrimSpace(h)) == name
+		}
+		if !keyed {
+			return false
+		}
+	}
+	return true
+}
+
+// add an entry, evicting the least recently used to make room
+func (self *Cache) store(e *cacheEntry) {
+	if e.size > self.opts.MaxBytes {
+		return
+	}
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if el, ok := self.items[e.key]; ok {
+		self.remove(el)
+	}
+	for self.size+e.size > self.opts.MaxBytes && self.ll.Len() > 0 {
+		self.remove(self.ll.Back())
+	}
+	self.items[e.key] = self.ll.PushFront(e)
+	self.size += e.size
+	v, ok := self.vary[e.base]
+	if !ok {
+		v = &varyNames{}
+		self.vary[e.base] = v
+	}
+	v.names = e.names
+	v.n++
+}
+
+// remove an element, dropping the vary names of its base key with the
+// last entry under it. caller holds the lock
+func (self *Cache) remove(el *list.Element) {
+	e := self.ll.Remove(el).(*cacheEntry)
+	delete(self.items, e.key)
+	self.size -= e.size
+	if v, ok := self.vary[e.base]; ok {
+		if v.n--; v.n <= 0 {
+			delete(self.vary, e.base)
+		}
+	}
+}
+
+// write a cached response, answering conditional requests with 304
+func (self *cacheEntry) serve(w http.ResponseWriter, r *http.Request) {
+	hdr := w.Header()
+	for k, v := range self.header {
+		hdr[k] = v
+	}
+	hdr.Set("Age", strconv.Itoa(int(time.Since(self.stored).Seconds())))
+	if etagMatch(r.Header.Get("If-None-Match"), self.etag) {
+		hdr.Del("Content-Length")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	hdr.Set("Content-Length", strconv.Itoa(len(self.body)))
+	w.WriteHeader(self.status)
+	w.Write(self.body)
+}
+
+// test an if-none-match header against an etag, using weak comparison
+func etagMatch(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, v := range strings.Split(header, ",") {
+		v = strings.TrimSpace(v)
+		if v == "*" || strings.TrimPrefix(v, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// parse a cache-control header into directives
+func parseCacheControl(s string) map[string]string {
+	cc := make(map[string]string)
+	for _, part := range strings.Split(s, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if k != "" {
+			cc[strings.ToLower(k)] = strings.Trim(v, `"`)
+		}
+	}
+	return cc
+}
+
+// parse a delta-seconds value
+func seconds(s string) time.Duration {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return time.Duration(n) * time.Second
+}
+
+// response writer buffering the response until the handler returns, or
+// passing it straight through once the handler flushes
+type captureWriter struct {
+	http.ResponseWriter
+	header      http.Header
+	status      int
+	buf         bytes.Buffer
+	passthrough bool
+}
+
+// return the buffered header
+func (self *captureWriter) Header() http.Header {
+	if self.passthrough {
+		return self.ResponseWriter.Header()
+	}
+	return self.header
+}
+
+// record the status code
+func (self *captureWriter) WriteHeader(status int) {
+	if self.passthrough {
+		self.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if self.status == 0 {
+		self.status = status
+	}
+}
+
+// buffer the body
+func (self *captureWriter) Write(b []byte) (int, error) {
+	if self.passthrough {
+		return self.ResponseWriter.Write(b)
+	}
+	if self.status == 0 {
+		self.status = http.StatusOK
+	}
+	return self.buf.Write(b)
+}
+
+// switch to streaming, the response is no longer cached
+func (self *captureWriter) Flush() {
+	if !self.passthrough {
+		self.commit()
+		self.passthrough = true
+	}
+	http.NewResponseController(self.ResponseWriter).Flush()
+}
+
+// write the buffered response to the client
+func (self *captureWriter) commit() {
+	hdr := self.ResponseWriter.Header()
+	for k, v := range self.header {
+		hdr[k] = v
+	}
+	hdr.Del(CACHE_TAG)
+	if self.status != 0 {
+		self.ResponseWriter.WriteHeader(self.status)
+	}
+	self.ResponseWriter.Write(self.buf.Bytes())
+}
+
+// return the recorded status, 200 if nothing was written
+func (self *captureWriter) Status() int {
+	if self.status == 0 {
+		return http.StatusOK
+	}
+	return self.status
+}
+
+// expose the underlying writer to http.ResponseController
+func (self *captureWriter) Unwrap() http.ResponseWriter {
+	return self.ResponseWriter
+}
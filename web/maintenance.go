@@ -0,0 +1,230 @@
+// --------------
+// maintenance.go ::: runtime maintenance mode switch
+// --------------
+// Copyright (c) 2013-Present, Scott Cagno. All rights reserved.
+// This source code is governed by a BSD-style license.
+
+package web
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// renders a named template, satisfied by tmpl.TemplateStore
+type Renderer interface {
+	Render(w http.ResponseWriter, name string, m interface{})
+}
+
+// maintenance mode middleware
+type Maintenance struct {
+	RetryAfter time.Duration
+	Renderer   Renderer
+	Template   string
+	AllowPaths []string
+	Allow      func(r *http.Request) bool
+	nets       []*net.IPNet
+	enabled    bool
+	message    string
+	since      time.Time
+	byFile     bool
+	mu         sync.RWMutex
+}
+
+// return a new maintenance switch, initially disabled
+func NewMaintenance() *Maintenance {
+	return &Maintenance{
+		RetryAfter: 5 * time.Minute,
+		Template:   "maintenance.html",
+	}
+}
+
+// let addresses or cidr ranges through while enabled
+func (self *Maintenance) AllowIP(addrs ...string) error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	for _, addr := range addrs {
+		if !strings.Contains(addr, "/") {
+			if strings.Contains(addr, ":") {
+				addr += "/128"
+			} else {
+				addr += "/32"
+			}
+		}
+		_, n, err := net.ParseCIDR(addr)
+		if err != nil {
+			return err
+		}
+		self.nets = append(self.nets, n)
+	}
+	return nil
+}
+
+// turn maintenance mode on with a message for visitors
+func (self *Maintenance) Enable(message string) {
+	self.enable(message, false)
+}
+
+// turn maintenance mode on, noting whether a watched file did it
+func (self *Maintenance) enable(message string, byFile bool) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if !self.enabled {
+		self.since = time.Now()
+	}
+	self.enabled, self.message, self.byFile = true, message, byFile
+}
+
+// turn maintenance mode off
+func (self *Maintenance) Disable() {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	self.enabled, self.message, self.byFile = false, "", false
+}
+
+// report whether maintenance mode is on
+func (self *Maintenance) Enabled() bool {
+	self.mu.RLock()
+	defer self.mu.RUnlock()
+	return self.enabled
+}
+
+// wrap a handler, answering 503 while enabled unless the request is let through
+func (self *Maintenance) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		self.mu.RLock()
+		enabled, message, since := self.enabled, self.message, self.since
+		self.mu.RUnlock()
+		if !enabled || self.allowed(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		secs := int(self.RetryAfter.Seconds())
+		w.Header().Set("Retry-After", strconv.Itoa(secs))
+		w.Header().Set("Cache-Control", "no-store")
+		if self.Renderer == nil {
+			http.Error(w, "Service Unavailable - "+message, http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		self.Renderer.Render(w, self.Template, map[string]interface{}{
+			"message": message,
+			"since":   since,
+			"retry":   secs,
+		})
+	})
+}
+
+// test whether a request may pass while enabled
+func (self *Maintenance) allowed(r *http.Request) bool {
+	for _, p := range self.AllowPaths {
+		if strings.HasPrefix(r.URL.Path, p) {
+			return true
+		}
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		if ip := net.ParseIP(host); ip != nil {
+			self.mu.RLock()
+			defer self.mu.RUnlock()
+			for _, n := range self.nets {
+				if n.Contains(ip) {
+					return true
+				}
+			}
+		}
+	}
+	return self.Allow != nil && self.Allow(r)
+}
+
+// admin api, GET reports the state and POST sets it from the enabled and
+// message form values. mount it behind authentication and in AllowPaths
+func (self *Maintenance) AdminHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			on, err := strconv.ParseBool(r.FormValue("enabled"))
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+			if on {
+				self.Enable(r.FormValue("message"))
+			} else {
+				self.Disable()
+			}
+		}
+		self.mu.RLock()
+		state := map[string]interface{}{"enabled": self.enabled, "message": self.message}
+		if self.enabled {
+			state["since"] = self.since
+		}
+		self.mu.RUnlock()
+		writeJSON(w, http.StatusOK, state)
+	})
+}
+
+// toggle maintenance mode whenever one of the signals is received (ie. SIGUSR1)
+func (self *Maintenance) ToggleOn(sigs ...os.Signal) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, sigs...)
+	go func() {
+		for range c {
+			if self.Enabled() {
+				self.Disable()
+			} else {
+				self.Enable("")
+			}
+		}
+	}()
+}
+
+// poll a flag file, enabling maintenance mode when it appears with its
+// contents as the message, and disabling it again when the file goes away.
+// maintenance turned on or off otherwise is left alone. call the returned
+// func to stop
+func (self *Maintenance) WatchFile(path string, interval time.Duration) func() {
+	done := make(chan struct{})
+	present, message := false, ""
+	check := func() {
+		b, err := os.ReadFile(path)
+		switch {
+		case err == nil:
+			m := strings.TrimSpace(string(b))
+			self.mu.RLock()
+			update := !present || self.byFile && self.message != m
+			self.mu.RUnlock()
+			if update {
+				self.enable(m, true)
+			}
+			present, message = true, m
+		case os.IsNotExist(err) && present:
+			present = false
+			self.mu.Lock()
+			if self.enabled && self.byFile && self.message == message {
+				self.enabled, self.message, self.byFile = false, "", false
+			}
+			self.mu.Unlock()
+		}
+	}
+	check()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				check()
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
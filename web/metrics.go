@@ -0,0 +1,201 @@
+// ----------
+// metrics.go ::: request metrics and prometheus text exposition
+// ----------
+// Copyright (c) 2013-Present, Scott Cagno. All rights reserved.
+// This source code is governed by a BSD-style license.
+
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// default latency histogram buckets, in seconds
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// label used for requests that matched no route
+const UNMATCHED = "unmatched"
+
+// request counter key
+type counterKey struct {
+	route, method, code string
+}
+
+// latency histogram
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// gauge sampled at exposition time
+type gauge struct {
+	name, help string
+	fn         func() float64
+}
+
+// request metrics collector and exposition handler
+type Metrics struct {
+	buckets  []float64
+	requests map[counterKey]uint64
+	latency  map[string]*histogram
+	gauges   []gauge
+	inflight int64
+	mu       sync.Mutex
+}
+
+// return a new metrics collector
+func NewMetrics() *Metrics {
+	return &Metrics{
+		buckets:  DefaultBuckets,
+		requests: make(map[counterKey]uint64),
+		latency:  make(map[string]*histogram),
+	}
+}
+
+// register a gauge sampled on each scrape, ie. store.Len or balancer.Pending
+func (self *Metrics) Gauge(name, help string, fn func() float64) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	self.gauges = append(self.gauges, gauge{name, help, fn})
+}
+
+// wrap a handler (usually the multiplexer), recording per route metrics
+func (self *Metrics) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&self.inflight, 1)
+		defer atomic.AddInt64(&self.inflight, -1)
+		r, rt := withRoute(r)
+		sw := &statusWriter{ResponseWriter: w}
+		start := time.Now()
+		h.ServeHTTP(sw, r)
+		pattern := rt.pattern
+		if pattern == "" {
+			pattern = UNMATCHED
+		}
+		self.observe(pattern, r.Method, sw.Status(), time.Since(start))
+	})
+}
+
+// record a single request
+func (self *Metrics) observe(route, method string, status int, d time.Duration) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	self.requests[counterKey{route, method, strconv.Itoa(status)}]++
+	h, ok := self.latency[route]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(self.buckets))}
+		self.latency[route] = h
+	}
+	secs := d.Seconds()
+	for i, b := range self.buckets {
+		if secs <= b {
+			h.counts[i]++
+		}
+	}
+	h.sum += secs
+	h.count++
+}
+
+// write all metrics in the prometheus text format
+func (self *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	self.mu.Lock()
+	keys := make([]counterKey, 0, len(self.requests))
+	for k := range self.requests {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.route != b.route {
+			return a.route < b.route
+		}
+		if a.method != b.method {
+			return a.method < b.method
+		}
+		return a.code < b.code
+	})
+	buf.WriteString("# HELP http_requests_total Total http requests by route, method and status code.\n")
+	buf.WriteString("# TYPE http_requests_total counter\n")
+	for _, k := range keys {
+		fmt.Fprintf(&buf, "http_requests_total{route=%s,method=%s,code=%s} %d\n",
+			quote(k.route), quote(k.method), quote(k.code), self.requests[k])
+	}
+	routes := make([]string, 0, len(self.latency))
+	for route := range self.latency {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+	buf.WriteString("# HELP http_request_duration_seconds Http request latency by route.\n")
+	buf.WriteString("# TYPE http_request_duration_seconds histogram\n")
+	for _, route := range routes {
+		h := self.latency[route]
+		for i, b := range self.buckets {
+			fmt.Fprintf(&buf, "http_request_duration_seconds_bucket{route=%s,le=\"%s\"} %d\n",
+				quote(route), strconv.FormatFloat(b, 'g', -1, 64), h.counts[i])
+		}
+		fmt.Fprintf(&buf, "http_request_duration_seconds_bucket{route=%s,le=\"+Inf\"} %d\n", quote(route), h.count)
+		fmt.Fprintf(&buf, "http_request_duration_seconds_sum{route=%s} %s\n", quote(route), strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(&buf, "http_request_duration_seconds_count{route=%s} %d\n", quote(route), h.count)
+	}
+	gauges := append([]gauge(nil), self.gauges...)
+	self.mu.Unlock()
+	buf.WriteString("# HELP http_requests_in_flight Http requests currently being served.\n")
+	buf.WriteString("# TYPE http_requests_in_flight gauge\n")
+	fmt.Fprintf(&buf, "http_requests_in_flight %d\n", atomic.LoadInt64(&self.inflight))
+	for _, g := range gauges {
+		fmt.Fprintf(&buf, "# HELP %s %s\n", g.name, strings.Replace(g.help, "\n", " ", -1))
+		fmt.Fprintf(&buf, "# TYPE %s gauge\n", g.name)
+		fmt.Fprintf(&buf, "%s %s\n", g.name, strconv.FormatFloat(g.fn(), 'g', -1, 64))
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+// quote and escape a label value
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+// response writer recording the status code
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+// record the status code
+func (self *statusWriter) WriteHeader(status int) {
+	if self.status == 0 {
+		self.status = status
+	}
+	self.ResponseWriter.WriteHeader(status)
+}
+
+// record an implicit 200
+func (self *statusWriter) Write(b []byte) (int, error) {
+	if self.status == 0 {
+		self.status = http.StatusOK
+	}
+	return self.ResponseWriter.Write(b)
+}
+
+// return the recorded status, 200 if nothing was written
+func (self *statusWriter) Status() int {
+	if self.status == 0 {
+		return http.StatusOK
+	}
+	return self.status
+}
+
+// expose the underlying writer to http.ResponseController
+func (self *statusWriter) Unwrap() http.ResponseWriter {
+	return self.ResponseWriter
+}
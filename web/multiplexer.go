@@ -7,6 +7,7 @@
 package web
 
 import (
+	"context"
 	"net/http"
 	"net/url"
 	"strings"
@@ -31,6 +32,11 @@ func (self *Multiplexer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			if len(params) > 0 {
 				r.URL.RawQuery = url.Values(params).Encode() + "&" + r.URL.RawQuery
 			}
+			if rt, ok := r.Context().Value(routeKey{}).(*route); ok {
+				rt.pattern = h.path
+			} else {
+				r = r.WithContext(context.WithValue(r.Context(), routeKey{}, &route{h.path}))
+			}
 			h.ServeHTTP(w, r)
 			return
 		}
@@ -103,6 +109,43 @@ func (self *Multiplexer) Static(path, folder string) {
 	self.Handle("GET", path, h)
 }
 
+type routeKey struct{}
+
+// route matched by the multiplexer, shared with outer middleware
+type route struct {
+	pattern string
+}
+
+// attach an empty route to the request, for middleware wrapping the
+// multiplexer that needs the matched pattern after it has served
+func withRoute(r *http.Request) (*http.Request, *route) {
+	if rt, ok := r.Context().Value(routeKey{}).(*route); ok {
+		return r, rt
+	}
+	rt := &route{}
+	return r.WithContext(context.WithValue(r.Context(), routeKey{}, rt)), rt
+}
+
+// attach a route recorder to the request, the returned func reports the
+// pattern matched once the multiplexer has served it
+func RecordRoute(r *http.Request) (*http.Request, func() string) {
+	r, rt := withRoute(r)
+	return r, func() string { return rt.pattern }
+}
+
+// return the registered pattern matched for the request
+func Pattern(r *http.Request) string {
+	if rt, ok := r.Context().Value(routeKey{}).(*route); ok {
+		return rt.pattern
+	}
+	return ""
+}
+
+// return a named path parameter matched by the multiplexer
+func Param(r *http.Request, name string) string {
+	return r.URL.Query().Get(":" + name)
+}
+
 // handler
 type Handler struct {
 	path string
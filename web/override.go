@@ -0,0 +1,44 @@
+// -----------
+// override.go ::: html form method override
+// -----------
+// Copyright (c) 2013-Present, Scott Cagno. All rights reserved.
+// This source code is governed by a BSD-style license.
+
+package web
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/scottcagno/net_kit/frms"
+)
+
+// header consulted for method overrides
+const METHOD_HEADER = "X-HTTP-Method-Override"
+
+// middleware letting a post request stand in for put, patch or delete,
+// using the X-HTTP-Method-Override header or the frms.METHOD_FIELD field.
+// only urlencoded bodies are read for the field, multipart bodies are left
+// for the handler to stream
+func MethodOverride(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			method := r.Header.Get(METHOD_HEADER)
+			if method == "" && urlencoded(r) {
+				method = r.PostFormValue(frms.METHOD_FIELD)
+			}
+			switch method = strings.ToUpper(method); method {
+			case "PUT", "PATCH", "DELETE":
+				r.Method = method
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// report whether a request body is an urlencoded form
+func urlencoded(r *http.Request) bool {
+	ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return ct == "application/x-www-form-urlencoded"
+}
@@ -0,0 +1,199 @@
+// --------
+// proxy.go ::: reverse proxy routes
+// --------
+// Copyright (c) 2013-Present, Scott Cagno. All rights reserved.
+// This source code is governed by a BSD-style license.
+
+package web
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/scottcagno/net_kit/load"
+)
+
+// proxied backend service
+type Upstream struct {
+	Target  string
+	Timeout time.Duration
+}
+
+// upstream with its parsed target and transport, fails counts consecutive
+// transport errors and down is when an ejected upstream is tried again
+type upstream struct {
+	target    *url.URL
+	transport http.RoundTripper
+	fails     int
+	down      time.Time
+	mu        sync.Mutex
+}
+
+// reverse proxy spreading requests across upstreams, each request goes to
+// the upstream with the fewest requests in flight. an upstream failing
+// MaxFails requests in a row (connection errors and timeouts, not error
+// statuses) is left out for FailTimeout, then ejected again on its next
+// failure until one succeeds. when every upstream is out all are tried.
+// MaxFails of 0 disables ejection
+type ReverseProxy struct {
+	Rewrite     func(path string) string
+	Error       func(w http.ResponseWriter, r *http.Request, status int)
+	MaxFails    int
+	FailTimeout time.Duration
+	picker      *load.Picker
+	proxy       *httputil.ReverseProxy
+}
+
+type upstreamKey struct{}
+
+// return a new reverse proxy, panics on an invalid upstream target
+func NewReverseProxy(upstreams ...Upstream) *ReverseProxy {
+	if len(upstreams) == 0 {
+		panic("web: reverse proxy needs at least one upstream")
+	}
+	values := make([]interface{}, 0, len(upstreams))
+	for _, u := range upstreams {
+		target, err := url.Parse(u.Target)
+		if err != nil || target.Host == "" {
+			panic("web: invalid upstream target " + u.Target)
+		}
+		values = append(values, &upstream{target: target, transport: newTransport(u.Timeout)})
+	}
+	self := &ReverseProxy{
+		MaxFails:    3,
+		FailTimeout: 10 * time.Second,
+		picker:      load.NewPicker(values...),
+	}
+	self.proxy = &httputil.ReverseProxy{
+		Rewrite:      self.rewrite,
+		Transport:    self,
+		ErrorHandler: self.fail,
+	}
+	return self
+}
+
+// register a reverse proxy for every method on path. prefix paths (ending
+// in '/') are mounted at the upstream path, ie. "/legacy/" forwards
+// "/legacy/users" to "<target>/users"
+func (self *Multiplexer) Proxy(path string, upstreams ...Upstream) *ReverseProxy {
+	p := NewReverseProxy(upstreams...)
+	for _, method := range []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"} {
+		self.Handle(method, path, p)
+	}
+	return p
+}
+
+// implement http.Handler
+func (self *ReverseProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	worker := self.picker.PickFunc(func(v interface{}) bool {
+		return v.(*upstream).available(now)
+	})
+	defer self.picker.Done(worker)
+	ctx := context.WithValue(r.Context(), upstreamKey{}, worker.Value.(*upstream))
+	self.proxy.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// implement http.RoundTripper using the picked upstream's transport,
+// tracking its health unless the client went away
+func (self *ReverseProxy) RoundTrip(r *http.Request) (*http.Response, error) {
+	u := r.Context().Value(upstreamKey{}).(*upstream)
+	res, err := u.transport.RoundTrip(r)
+	switch {
+	case err == nil:
+		u.succeeded()
+	case r.Context().Err() != context.Canceled && self.MaxFails > 0:
+		u.failed(self.MaxFails, self.FailTimeout)
+	}
+	return res, err
+}
+
+// report whether the upstream is not ejected at now
+func (self *upstream) available(now time.Time) bool {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	return !now.Before(self.down)
+}
+
+// count a transport error, ejecting the upstream for timeout once it
+// reaches max in a row
+func (self *upstream) failed(max int, timeout time.Duration) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if self.fails++; self.fails >= max {
+		self.down = time.Now().Add(timeout)
+	}
+}
+
+// reset the error count after a response
+func (self *upstream) succeeded() {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	self.fails = 0
+}
+
+// point the outbound request at the picked upstream
+func (self *ReverseProxy) rewrite(pr *httputil.ProxyRequest) {
+	u := pr.In.Context().Value(upstreamKey{}).(*upstream)
+	path := pr.In.URL.Path
+	if pattern := Pattern(pr.In); strings.HasSuffix(pattern, "/") && !strings.Contains(pattern, ":") {
+		path = "/" + strings.TrimPrefix(path, pattern)
+	}
+	if self.Rewrite != nil {
+		path = self.Rewrite(path)
+	}
+	pr.Out.URL.Path, pr.Out.URL.RawPath = path, ""
+	pr.Out.URL.RawQuery = stripParams(pr.In.URL.RawQuery)
+	pr.SetURL(u.target)
+	pr.SetXForwarded()
+	pr.Out.Host = u.target.Host
+}
+
+// answer a failed upstream request with 504 on timeouts, otherwise 502
+func (self *ReverseProxy) fail(w http.ResponseWriter, r *http.Request, err error) {
+	status := http.StatusBadGateway
+	var ne net.Error
+	if errors.Is(err, context.DeadlineExceeded) || errors.As(err, &ne) && ne.Timeout() {
+		status = http.StatusGatewayTimeout
+	}
+	if errors.Is(err, context.Canceled) {
+		// client went away, nobody to answer
+		return
+	}
+	if self.Error != nil {
+		self.Error(w, r, status)
+		return
+	}
+	http.Error(w, http.StatusText(status), status)
+}
+
+// transport bounding dial and response header time, upgraded connections
+// and streamed bodies are left unbounded
+func newTransport(timeout time.Duration) http.RoundTripper {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	if timeout > 0 {
+		t.DialContext = (&net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second}).DialContext
+		t.ResponseHeaderTimeout = timeout
+	}
+	return t
+}
+
+// drop the ":name" path parameters the multiplexer adds to the query
+func stripParams(raw string) string {
+	parts := strings.Split(raw, "&")
+	kept := parts[:0]
+	for _, p := range parts {
+		if p == "" || strings.HasPrefix(p, ":") || strings.HasPrefix(p, "%3A") {
+			continue
+		}
+		kept = append(kept, p)
+	}
+	return strings.Join(kept, "&")
+}
@@ -0,0 +1,244 @@
+// -----------
+// resource.go ::: rest resource controller over a data wrapper
+// -----------
+// Copyright (c) 2013-Present, Scott Cagno. All rights reserved.
+// This source code is governed by a BSD-style license.
+
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/scottcagno/net_kit/data"
+)
+
+// rest resource controller, any action handler set overrides the default
+type Resource struct {
+	Data     data.DataWrapper
+	New      func() interface{}
+	NewList  func() interface{}
+	Selector func(id string) interface{}
+	List     http.HandlerFunc
+	Show     http.HandlerFunc
+	Create   http.HandlerFunc
+	Update   http.HandlerFunc
+	Delete   http.HandlerFunc
+}
+
+// register the conventional rest routes for a resource, panics if a
+// default action lacks what it needs
+func (self *Multiplexer) Resource(path string, ctrl *Resource) {
+	if ctrl.Data == nil && (ctrl.List == nil || ctrl.Create == nil || ctrl.Show == nil || ctrl.Update == nil || ctrl.Delete == nil) {
+		panic("web: resource " + path + " needs Data")
+	}
+	if ctrl.New == nil && (ctrl.Create == nil || ctrl.Show == nil || ctrl.Update == nil) {
+		panic("web: resource " + path + " needs New")
+	}
+	if ctrl.NewList == nil && ctrl.List == nil {
+		panic("web: resource " + path + " needs NewList")
+	}
+	path = strings.TrimRight(path, "/")
+	item := path + "/:id"
+	self.Get(path, pick(ctrl.List, ctrl.list))
+	self.Post(path, pick(ctrl.Create, ctrl.create))
+	self.Get(item, pick(ctrl.Show, ctrl.show))
+	self.Put(item, pick(ctrl.Update, ctrl.update))
+	self.Delete(item, pick(ctrl.Delete, ctrl.delete))
+}
+
+// return the override if set, otherwise the default action
+func pick(override, def http.HandlerFunc) http.HandlerFunc {
+	if override != nil {
+		return override
+	}
+	return def
+}
+
+// return the selector for the id path parameter, by default matching _id
+// as an object id when the parameter is one
+func (self *Resource) selector(r *http.Request) interface{} {
+	id := Param(r, "id")
+	if self.Selector != nil {
+		return self.Selector(id)
+	}
+	return map[string]interface{}{"_id": data.Id(id)}
+}
+
+// default list action
+func (self *Resource) list(w http.ResponseWriter, r *http.Request) {
+	list := self.NewList()
+	if err := asError(self.Data.Return(list)); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// default show action
+func (self *Resource) show(w http.ResponseWriter, r *http.Request) {
+	item := self.New()
+	if err := asError(self.Data.Return(self.selector(r), item, 1)); err != nil {
+		if err == data.ErrNotFound {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, item)
+}
+
+// default create action
+func (self *Resource) create(w http.ResponseWriter, r *http.Request) {
+	item := self.New()
+	if err := decodeBody(r, item); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	data.EnsureId(item)
+	if err := asError(self.Data.Insert(item)); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, item)
+}
+
+// default update action
+func (self *Resource) update(w http.ResponseWriter, r *http.Request) {
+	item := self.New()
+	if err := decodeBody(r, item); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	ret := self.Data.Update(self.selector(r), item)
+	if err := asError(ret); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if n, ok := ret.(int); ok && n == 0 {
+		writeError(w, http.StatusNotFound, data.ErrNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, item)
+}
+
+// default delete action
+func (self *Resource) delete(w http.ResponseWriter, r *http.Request) {
+	ret := self.Data.Delete(self.selector(r))
+	if err := asError(ret); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if n, ok := ret.(int); ok && n == 0 {
+		writeError(w, http.StatusNotFound, data.ErrNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// data wrappers report failure by returning an error value
+func asError(ret interface{}) error {
+	err, _ := ret.(error)
+	return err
+}
+
+// write a json response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(b)
+}
+
+// write a json error response
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// decode a json or form encoded request body into v
+func decodeBody(r *http.Request, v interface{}) error {
+	typ, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if typ == "application/json" {
+		return json.NewDecoder(r.Body).Decode(v)
+	}
+	if err := r.ParseMultipartForm(32 << 20); err != nil && err != http.ErrNotMultipart {
+		return err
+	}
+	return decodeForm(r.PostForm, v)
+}
+
+// decode form values into the fields of a struct pointer, keyed by json name
+func decodeForm(vals url.Values, v interface{}) error {
+	val := reflect.Indirect(reflect.ValueOf(v))
+	if val.Kind() != reflect.Struct {
+		return fmt.Errorf("expected pointer to struct, got %T", v)
+	}
+	typ := val.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		fld := typ.Field(i)
+		if fld.PkgPath != "" {
+			continue
+		}
+		name := strings.Split(fld.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = fld.Name
+		}
+		s, ok := vals[name]
+		if !ok || len(s) == 0 {
+			continue
+		}
+		if err := setString(val.Field(i), s[0]); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// set a value from its string form
+func setString(v reflect.Value, s string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
@@ -7,14 +7,31 @@
 package web
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync"
 	"time"
 )
 
+// first file descriptor passed by socket activation
+const LISTEN_FDS_START = 3
+
+// returned by Restart when no listeners are tracked, ie. after Serve
+var ErrNoListeners = errors.New("web: no listeners to pass on, use ServeListeners")
+
+// ShutdownTimeout bounds the graceful shutdown, RestartTimeout how long
+// Restart waits for the new process to start serving
 type WebServer struct {
 	http.Server
+	ShutdownTimeout time.Duration
+	RestartTimeout  time.Duration
+	listeners       []net.Listener
+	mu              sync.Mutex
 }
 
 func NewWebServer() *WebServer {
@@ -23,6 +40,8 @@ func NewWebServer() *WebServer {
 	server.WriteTimeout = 10 * time.Second
 	server.MaxHeaderBytes = 1 << 22
 	server.TLSConfig = nil
+	server.ShutdownTimeout = 30 * time.Second
+	server.RestartTimeout = 30 * time.Second
 	return server
 }
 
@@ -34,3 +53,73 @@ func (self *WebServer) Serve(host string, handler http.Handler) {
 		log.Fatal(fmt.Sprintf("%v : %s\n", time.Now(), err))
 	}
 }
+
+// serve handler on every listener at once, blocks until all have stopped
+func (self *WebServer) ServeListeners(handler http.Handler, listeners ...net.Listener) error {
+	if len(listeners) == 0 {
+		return errors.New("web: no listeners to serve")
+	}
+	self.Handler = handler
+	self.mu.Lock()
+	self.listeners = append(self.listeners, listeners...)
+	self.mu.Unlock()
+	errs := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func(l net.Listener) {
+			errs <- self.Server.Serve(l)
+		}(l)
+	}
+	notifyReady()
+	var first error
+	for range listeners {
+		if err := <-errs; err != nil && err != http.ErrServerClosed && first == nil {
+			first = err
+			self.Close()
+		}
+	}
+	return first
+}
+
+// listen on a tcp address or unix socket path
+func Listen(network, addr string) (net.Listener, error) {
+	if network == "unix" {
+		return ListenUnix(addr, 0660)
+	}
+	return net.Listen(network, addr)
+}
+
+// listen on a unix socket, replacing a stale socket file and setting perm
+func ListenUnix(path string, perm os.FileMode) (net.Listener, error) {
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if c, err := net.Dial("unix", path); err == nil {
+			c.Close()
+			return nil, fmt.Errorf("web: unix socket %s is in use", path)
+		}
+		os.Remove(path)
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, perm); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// restart whenever one of the given signals is received (ie. SIGHUP)
+func (self *WebServer) RestartOn(sigs ...os.Signal) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, sigs...)
+	go func() {
+		for range c {
+			if err := self.Restart(); err != nil {
+				log.Printf("%v : restart failed: %s\n", time.Now(), err)
+				continue
+			}
+			signal.Stop(c)
+			return
+		}
+	}()
+}
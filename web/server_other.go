@@ -0,0 +1,27 @@
+// ---------------
+// server_other.go ::: listener inheritance where unsupported
+// ---------------
+// Copyright (c) 2013-Present, Scott Cagno. All rights reserved.
+// This source code is governed by a BSD-style license.
+
+//go:build !unix
+
+package web
+
+import (
+	"errors"
+	"net"
+)
+
+// socket activation and listener passing need a unix system
+func Inherited() ([]net.Listener, error) {
+	return nil, nil
+}
+
+// restarts need a unix system
+func (self *WebServer) Restart() error {
+	return errors.New("web: restart is not supported on this platform")
+}
+
+// nothing waits for readiness without restarts
+func notifyReady() {}
@@ -0,0 +1,150 @@
+// --------------
+// server_unix.go ::: listener inheritance and restarts
+// --------------
+// Copyright (c) 2013-Present, Scott Cagno. All rights reserved.
+// This source code is governed by a BSD-style license.
+
+//go:build unix
+
+package web
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// pipe to the parent during Restart, written once this process serves
+var ready struct {
+	f  *os.File
+	mu sync.Mutex
+}
+
+// return listeners passed by socket activation (LISTEN_FDS), or by a
+// parent process during Restart. LISTEN_PID must match this process when set
+func Inherited() ([]net.Listener, error) {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+	defer os.Unsetenv("LISTEN_READY")
+	if pid := os.Getenv("LISTEN_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	if fd, err := strconv.Atoi(os.Getenv("LISTEN_READY")); err == nil {
+		syscall.CloseOnExec(fd)
+		ready.mu.Lock()
+		ready.f = os.NewFile(uintptr(fd), "ready")
+		ready.mu.Unlock()
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	listeners := make([]net.Listener, 0, n)
+	for i := 0; i < n; i++ {
+		fd := LISTEN_FDS_START + i
+		syscall.CloseOnExec(fd)
+		name := "listener-" + strconv.Itoa(i)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(fd), name)
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("web: inherited fd %d: %v", fd, err)
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// tell the parent of a Restart that this process serves
+func notifyReady() {
+	ready.mu.Lock()
+	defer ready.mu.Unlock()
+	if ready.f != nil {
+		ready.f.Write([]byte{1})
+		ready.f.Close()
+		ready.f = nil
+	}
+}
+
+// start a copy of this process with the current listeners and shut down
+// gracefully once it serves them. the child picks the listeners up with
+// Inherited and reports back from ServeListeners, a child not serving
+// within RestartTimeout is killed and this process keeps serving
+func (self *WebServer) Restart() error {
+	self.mu.Lock()
+	listeners := append([]net.Listener(nil), self.listeners...)
+	self.mu.Unlock()
+	if len(listeners) == 0 {
+		return ErrNoListeners
+	}
+	files := make([]*os.File, 0, len(listeners))
+	names := make([]string, 0, len(listeners))
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, l := range listeners {
+		fl, ok := l.(interface {
+			File() (*os.File, error)
+		})
+		if !ok {
+			return fmt.Errorf("web: listener %s cannot be passed on", l.Addr())
+		}
+		f, err := fl.File()
+		if err != nil {
+			return err
+		}
+		files = append(files, f)
+		names = append(names, l.Addr().Network())
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	files = append(files, w)
+	cmd := exec.Command(os.Args[0], os.Args[1:]...)
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, "LISTEN_") {
+			cmd.Env = append(cmd.Env, kv)
+		}
+	}
+	cmd.Env = append(cmd.Env, "LISTEN_FDS="+strconv.Itoa(len(listeners)), "LISTEN_FDNAMES="+strings.Join(names, ":"),
+		"LISTEN_READY="+strconv.Itoa(LISTEN_FDS_START+len(listeners)))
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = files
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	w.Close()
+	r.SetReadDeadline(time.Now().Add(self.RestartTimeout))
+	if _, err := r.Read(make([]byte, 1)); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return fmt.Errorf("web: restarted process did not start serving: %v", err)
+	}
+	for _, l := range listeners {
+		if ul, ok := l.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), self.ShutdownTimeout)
+	defer cancel()
+	return self.Shutdown(ctx)
+}
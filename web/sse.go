@@ -0,0 +1,251 @@
+// ------
+// sse.go ::: server-sent event streams
+// ------
+// Copyright (c) 2013-Present, Scott Cagno. All rights reserved.
+// This source code is governed by a BSD-style license.
+
+package web
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrNoFlush = errors.New("sse: response writer cannot flush")
+
+// server-sent event
+type Event struct {
+	Id, Event, Data string
+	Retry           time.Duration
+}
+
+// event stream handler, registrable on any multiplexer route
+type EventSource struct {
+	Heartbeat time.Duration
+	Retry     time.Duration
+	Handler   func(es *EventStream)
+}
+
+// return a new event source with a default heartbeat
+func NewEventSource(h func(es *EventStream)) *EventSource {
+	return &EventSource{
+		Heartbeat: 15 * time.Second,
+		Handler:   h,
+	}
+}
+
+// open the stream, run the handler and heartbeat until either finishes
+func (self *EventSource) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+	hdr := w.Header()
+	hdr.Set("Content-Type", "text/event-stream")
+	hdr.Set("Cache-Control", "no-cache")
+	hdr.Set("Connection", "keep-alive")
+	hdr.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+	es := &EventStream{
+		Request:     r,
+		LastEventId: r.Header.Get("Last-Event-ID"),
+		w:           w,
+		rc:          rc,
+	}
+	if es.LastEventId == "" {
+		es.LastEventId = r.URL.Query().Get("lastEventId")
+	}
+	if self.Retry > 0 {
+		es.Send(Event{Retry: self.Retry})
+	}
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	if self.Heartbeat > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ticker := time.NewTicker(self.Heartbeat)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					es.Comment("heartbeat")
+				case <-stop:
+					return
+				case <-r.Context().Done():
+					return
+				}
+			}
+		}()
+	}
+	self.Handler(es)
+	close(stop)
+	wg.Wait()
+}
+
+// open event stream
+type EventStream struct {
+	Request     *http.Request
+	LastEventId string
+	w           http.ResponseWriter
+	rc          *http.ResponseController
+	mu          sync.Mutex
+}
+
+// write an event and flush it to the client
+func (self *EventStream) Send(ev Event) error {
+	var b strings.Builder
+	if ev.Id != "" {
+		fmt.Fprintf(&b, "id: %s\n", oneLine(ev.Id))
+	}
+	if ev.Event != "" {
+		fmt.Fprintf(&b, "event: %s\n", oneLine(ev.Event))
+	}
+	if ev.Retry > 0 {
+		fmt.Fprintf(&b, "retry: %d\n", ev.Retry.Milliseconds())
+	}
+	if ev.Data != "" || ev.Id != "" || ev.Event != "" {
+		for _, line := range strings.Split(strings.Replace(ev.Data, "\r\n", "\n", -1), "\n") {
+			fmt.Fprintf(&b, "data: %s\n", line)
+		}
+	}
+	b.WriteString("\n")
+	return self.write(b.String())
+}
+
+// write a data only event
+func (self *EventStream) SendData(data string) error {
+	return self.Send(Event{Data: data})
+}
+
+// write a comment line, ignored by clients
+func (self *EventStream) Comment(s string) error {
+	return self.write(": " + oneLine(s) + "\n\n")
+}
+
+// return a channel closed when the client disconnects
+func (self *EventStream) Done() <-chan struct{} {
+	return self.Request.Context().Done()
+}
+
+// write and flush under lock
+func (self *EventStream) write(s string) error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	select {
+	case <-self.Done():
+		return self.Request.Context().Err()
+	default:
+	}
+	if _, err := self.w.Write([]byte(s)); err != nil {
+		return err
+	}
+	if err := self.rc.Flush(); err != nil {
+		return ErrNoFlush
+	}
+	return nil
+}
+
+// strip line breaks from single line fields
+func oneLine(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
+
+// bounded event log assigning sequential ids, for last-event-id resume
+type EventLog struct {
+	size   int
+	next   uint64
+	events []Event
+	subs   map[chan Event]bool
+	mu     sync.Mutex
+}
+
+// return a new event log retaining the last size events
+func NewEventLog(size int) *EventLog {
+	return &EventLog{
+		size: size,
+		next: 1,
+		subs: make(map[chan Event]bool),
+	}
+}
+
+// append an event, assign it an id and deliver it to subscribers
+func (self *EventLog) Publish(event, data string) Event {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	ev := Event{Id: strconv.FormatUint(self.next, 10), Event: event, Data: data}
+	self.next++
+	self.events = append(self.events, ev)
+	if len(self.events) > self.size {
+		self.events = self.events[len(self.events)-self.size:]
+	}
+	for c := range self.subs {
+		select {
+		case c <- ev:
+		default:
+		}
+	}
+	return ev
+}
+
+// return retained events after the given id
+func (self *EventLog) Since(id string) []Event {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	n, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return nil
+	}
+	var events []Event
+	for _, ev := range self.events {
+		if i, _ := strconv.ParseUint(ev.Id, 10, 64); i > n {
+			events = append(events, ev)
+		}
+	}
+	return events
+}
+
+// subscribe to new events, call cancel when done
+func (self *EventLog) Subscribe() (<-chan Event, func()) {
+	c := make(chan Event, 64)
+	self.mu.Lock()
+	self.subs[c] = true
+	self.mu.Unlock()
+	return c, func() {
+		self.mu.Lock()
+		delete(self.subs, c)
+		self.mu.Unlock()
+	}
+}
+
+// stream an event log, replaying missed events before following new ones
+func (self *EventLog) Stream(es *EventStream) {
+	c, cancel := self.Subscribe()
+	defer cancel()
+	var last uint64
+	for _, ev := range self.Since(es.LastEventId) {
+		if es.Send(ev) != nil {
+			return
+		}
+		last, _ = strconv.ParseUint(ev.Id, 10, 64)
+	}
+	for {
+		select {
+		case ev := <-c:
+			if i, _ := strconv.ParseUint(ev.Id, 10, 64); i <= last {
+				continue
+			}
+			if es.Send(ev) != nil {
+				return
+			}
+		case <-es.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,284 @@
+// ---------
+// upload.go ::: streaming multipart file uploads
+// ---------
+// Copyright (c) 2013-Present, Scott Cagno. All rights reserved.
+// This source code is governed by a BSD-style license.
+
+package web
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// sniffing uses at most this many leading bytes
+const sniffLen = 512
+
+var (
+	ErrNotMultipart    = errors.New("upload: request is not multipart")
+	ErrFileTooLarge    = errors.New("upload: file exceeds size limit")
+	ErrTotalTooLarge   = errors.New("upload: request exceeds size limit")
+	ErrTypeNotAllowed  = errors.New("upload: content type not allowed")
+	ErrValueTooLarge   = errors.New("upload: form value exceeds size limit")
+	ErrUnexpectedField = errors.New("upload: unexpected file field")
+)
+
+// sniffed types accepted when AllowedTypes is empty, none of them renders
+// as a page when served back
+var UPLOAD_TYPES = []string{
+	"image/jpeg",
+	"image/png",
+	"image/gif",
+	"image/webp",
+	"application/pdf",
+	"application/zip",
+	"text/plain",
+}
+
+// upload options. AllowedTypes lists sniffed content types or "image/*"
+// style wildcards, UPLOAD_TYPES when empty and "*/*" for anything
+type UploadOptions struct {
+	Dir          string
+	MaxFileSize  int64
+	MaxTotalSize int64
+	MaxValueSize int64
+	AllowedTypes []string
+	Fields       []string
+}
+
+// return default upload options, storing into the project uploads directory
+func DefaultUploadOptions() UploadOptions {
+	return UploadOptions{
+		Dir:          "uploads",
+		MaxFileSize:  10 << 20,
+		MaxTotalSize: 50 << 20,
+		MaxValueSize: 1 << 20,
+	}
+}
+
+// stored upload metadata, suitable for DataWrapper.Insert
+type Upload struct {
+	Field       string    `json:"field" bson:"field"`
+	Name        string    `json:"name" bson:"name"`
+	Filename    string    `json:"filename" bson:"filename"`
+	Path        string    `json:"path" bson:"path"`
+	ContentType string    `json:"content_type" bson:"content_type"`
+	Size        int64     `json:"size" bson:"size"`
+	Sha256      string    `json:"sha256" bson:"sha256"`
+	Uploaded    time.Time `json:"uploaded" bson:"uploaded"`
+}
+
+// upload handler helper
+type Uploader struct {
+	opts UploadOptions
+}
+
+// return a new uploader
+func NewUploader(opts UploadOptions) *Uploader {
+	return &Uploader{opts}
+}
+
+// stream every file part of a multipart request to disk, returning the
+// stored files and the plain form values. on error nothing is kept. behind
+// csrf.Guard the token field has to come before the file fields
+func (self *Uploader) Save(w http.ResponseWriter, r *http.Request) ([]Upload, url.Values, error) {
+	if self.opts.MaxTotalSize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, self.opts.MaxTotalSize)
+	}
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, nil, ErrNotMultipart
+	}
+	var uploads []Upload
+	vals := make(url.Values)
+	fail := func(err error) ([]Upload, url.Values, error) {
+		for _, u := range uploads {
+			os.Remove(u.Path)
+		}
+		var mbe *http.MaxBytesError
+		if errors.As(err, &mbe) {
+			err = ErrTotalTooLarge
+		}
+		return nil, nil, err
+	}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fail(err)
+		}
+		name := part.FormName()
+		if part.FileName() == "" {
+			b, err := readLimited(part, self.opts.MaxValueSize, ErrValueTooLarge)
+			if err != nil {
+				return fail(err)
+			}
+			vals.Add(name, string(b))
+			continue
+		}
+		if !self.allowedField(name) {
+			return fail(ErrUnexpectedField)
+		}
+		u, err := self.store(name, part.FileName(), part)
+		if err != nil {
+			return fail(err)
+		}
+		uploads = append(uploads, u)
+	}
+	return uploads, vals, nil
+}
+
+// map an upload error to an http status code
+func UploadStatus(err error) int {
+	switch {
+	case err == nil:
+		return http.StatusOK
+	case errors.Is(err, ErrFileTooLarge), errors.Is(err, ErrTotalTooLarge), errors.Is(err, ErrValueTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, ErrTypeNotAllowed), errors.Is(err, ErrNotMultipart):
+		return http.StatusUnsupportedMediaType
+	}
+	return http.StatusBadRequest
+}
+
+// test whether a form field may carry files
+func (self *Uploader) allowedField(name string) bool {
+	if len(self.opts.Fields) == 0 {
+		return true
+	}
+	for _, f := range self.opts.Fields {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+// sniff, check and stream a single file part to disk
+func (self *Uploader) store(field, name string, part io.Reader) (Upload, error) {
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(part, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return Upload{}, err
+	}
+	head = head[:n]
+	typ := http.DetectContentType(head)
+	if !self.allowedType(typ) {
+		return Upload{}, fmt.Errorf("%w: %s", ErrTypeNotAllowed, typ)
+	}
+	if err := os.MkdirAll(self.opts.Dir, 0750); err != nil {
+		return Upload{}, err
+	}
+	filename := randomName() + extension(name, typ)
+	path := filepath.Join(self.opts.Dir, filename)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0640)
+	if err != nil {
+		return Upload{}, err
+	}
+	h := sha256.New()
+	src := io.MultiReader(bytes.NewReader(head), part)
+	var size int64
+	if self.opts.MaxFileSize > 0 {
+		size, err = io.Copy(io.MultiWriter(f, h), io.LimitReader(src, self.opts.MaxFileSize+1))
+		if err == nil && size > self.opts.MaxFileSize {
+			err = ErrFileTooLarge
+		}
+	} else {
+		size, err = io.Copy(io.MultiWriter(f, h), src)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+		return Upload{}, err
+	}
+	return Upload{
+		Field:       field,
+		Name:        filepath.Base(strings.Replace(name, "\\", "/", -1)),
+		Filename:    filename,
+		Path:        path,
+		ContentType: typ,
+		Size:        size,
+		Sha256:      hex.EncodeToString(h.Sum(nil)),
+		Uploaded:    time.Now(),
+	}, nil
+}
+
+// test a sniffed content type against the allowlist, ie. "image/*"
+func (self *Uploader) allowedType(typ string) bool {
+	types := self.opts.AllowedTypes
+	if len(types) == 0 {
+		types = UPLOAD_TYPES
+	}
+	typ, _, _ = mime.ParseMediaType(typ)
+	for _, allowed := range types {
+		if allowed == "*/*" || allowed == typ || strings.HasSuffix(allowed, "/*") && strings.HasPrefix(typ, allowed[:len(allowed)-1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// read a value, failing once it exceeds max bytes
+func readLimited(r io.Reader, max int64, tooLarge error) ([]byte, error) {
+	if max <= 0 {
+		return io.ReadAll(r)
+	}
+	b, err := io.ReadAll(io.LimitReader(r, max+1))
+	if err == nil && int64(len(b)) > max {
+		err = tooLarge
+	}
+	return b, err
+}
+
+// preferred extensions for common sniffed types
+var extensions = map[string]string{
+	"text/plain":      ".txt",
+	"text/html":       ".html",
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"image/gif":       ".gif",
+	"image/webp":      ".webp",
+	"application/pdf": ".pdf",
+	"application/zip": ".zip",
+}
+
+// keep the client extension only if it agrees with the sniffed type
+func extension(name, typ string) string {
+	ext := strings.ToLower(filepath.Ext(name))
+	base, _, _ := mime.ParseMediaType(typ)
+	if ext != "" {
+		if t, _, _ := mime.ParseMediaType(mime.TypeByExtension(ext)); t == base {
+			return ext
+		}
+	}
+	if ext, ok := extensions[base]; ok {
+		return ext
+	}
+	if exts, _ := mime.ExtensionsByType(base); len(exts) > 0 {
+		return exts[0]
+	}
+	return ".bin"
+}
+
+// random file name
+func randomName() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,478 @@
+// ------------
+// websocket.go ::: rfc 6455 websocket endpoints and broadcast hub
+// ------------
+// Copyright (c) 2013-Present, Scott Cagno. All rights reserved.
+// This source code is governed by a BSD-style license.
+
+package web
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// websocket opcodes
+const (
+	OpContinuation = 0x0
+	OpText         = 0x1
+	OpBinary       = 0x2
+	OpClose        = 0x8
+	OpPing         = 0x9
+	OpPong         = 0xa
+)
+
+// websocket close codes
+const (
+	CloseNormal        = 1000
+	CloseGoingAway     = 1001
+	CloseProtocolError = 1002
+	CloseInvalidData   = 1007
+	CloseTooBig        = 1009
+)
+
+const socketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+var (
+	ErrBadHandshake = errors.New("websocket: bad handshake")
+	ErrBadOrigin    = errors.New("websocket: origin not allowed")
+	ErrTooBig       = errors.New("websocket: message exceeds size limit")
+	ErrProtocol     = errors.New("websocket: protocol error")
+	ErrClosed       = errors.New("websocket: connection closed")
+)
+
+// websocket options
+type SocketOptions struct {
+	MaxMessage   int64
+	PingInterval time.Duration
+	PongWait     time.Duration
+	WriteWait    time.Duration
+	Protocols    []string
+	CheckOrigin  func(r *http.Request) bool
+}
+
+// return default websocket options
+func DefaultSocketOptions() SocketOptions {
+	return SocketOptions{
+		MaxMessage:   1 << 20,
+		PingInterval: 30 * time.Second,
+		PongWait:     60 * time.Second,
+		WriteWait:    10 * time.Second,
+	}
+}
+
+// websocket endpoint, upgrades requests and runs the handler
+type SocketServer struct {
+	Options SocketOptions
+	Handler func(ws *Socket)
+}
+
+// upgrade the connection and hand it to the handler
+func (self *SocketServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ws, err := Upgrade(w, r, self.Options)
+	if err != nil {
+		return
+	}
+	defer ws.Close()
+	self.Handler(ws)
+}
+
+// register a websocket handler using the default options
+func (self *Multiplexer) WebSocket(path string, h func(ws *Socket)) {
+	self.Handle("GET", path, &SocketServer{DefaultSocketOptions(), h})
+}
+
+// websocket connection
+type Socket struct {
+	Request  *http.Request
+	Protocol string
+	opts     SocketOptions
+	conn     net.Conn
+	br       *bufio.Reader
+	wmu      sync.Mutex
+	done     chan struct{}
+	once     sync.Once
+}
+
+// perform the server side opening handshake
+func Upgrade(w http.ResponseWriter, r *http.Request, opts SocketOptions) (*Socket, error) {
+	if r.Method != "GET" ||
+		!headerHas(r.Header, "Connection", "upgrade") ||
+		!headerHas(r.Header, "Upgrade", "websocket") ||
+		r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "Bad Request - websocket handshake expected", http.StatusBadRequest)
+		return nil, ErrBadHandshake
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if dec, err := base64.StdEncoding.DecodeString(key); err != nil || len(dec) != 16 {
+		http.Error(w, "Bad Request - invalid websocket key", http.StatusBadRequest)
+		return nil, ErrBadHandshake
+	}
+	check := opts.CheckOrigin
+	if check == nil {
+		check = sameOrigin
+	}
+	if !check(r) {
+		http.Error(w, "Forbidden - websocket origin not allowed", http.StatusForbidden)
+		return nil, ErrBadOrigin
+	}
+	var protocol string
+	for _, p := range headerTokens(r.Header, "Sec-WebSocket-Protocol") {
+		for _, q := range opts.Protocols {
+			if protocol == "" && p == q {
+				protocol = p
+			}
+		}
+	}
+	// through the response controller rather than asserting http.Hijacker,
+	// so writers wrapped by middleware (metrics, caching) are unwrapped
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, "Internal Server Error - connection cannot be hijacked", http.StatusInternalServerError)
+		return nil, err
+	}
+	h := sha1.New()
+	io.WriteString(h, key+socketGUID)
+	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(h.Sum(nil)) + "\r\n"
+	if protocol != "" {
+		resp += "Sec-WebSocket-Protocol: " + protocol + "\r\n"
+	}
+	conn.SetDeadline(time.Time{})
+	if _, err := conn.Write([]byte(resp + "\r\n")); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	ws := &Socket{
+		Request:  r,
+		Protocol: protocol,
+		opts:     opts,
+		conn:     conn,
+		br:       brw.Reader,
+		done:     make(chan struct{}),
+	}
+	ws.extend()
+	if opts.PingInterval > 0 {
+		go ws.keepalive()
+	}
+	return ws, nil
+}
+
+// read the next complete data message, answering control frames
+func (self *Socket) ReadMessage() (int, []byte, error) {
+	var op int
+	var msg []byte
+	for {
+		fin, frameOp, payload, err := self.readFrame()
+		if err != nil {
+			if err == ErrTooBig {
+				self.closeWith(CloseTooBig, err.Error())
+			} else if err == ErrProtocol {
+				self.closeWith(CloseProtocolError, err.Error())
+			}
+			return 0, nil, err
+		}
+		self.extend()
+		switch frameOp {
+		case OpPing:
+			if err := self.write(OpPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case OpPong:
+			continue
+		case OpClose:
+			code := CloseNormal
+			if len(payload) >= 2 {
+				code = int(binary.BigEndian.Uint16(payload))
+			}
+			self.closeWith(code, "")
+			return 0, nil, io.EOF
+		case OpText, OpBinary:
+			if msg != nil {
+				self.closeWith(CloseProtocolError, "expected continuation")
+				return 0, nil, ErrProtocol
+			}
+			op, msg = frameOp, payload
+		case OpContinuation:
+			if msg == nil {
+				self.closeWith(CloseProtocolError, "unexpected continuation")
+				return 0, nil, ErrProtocol
+			}
+			msg = append(msg, payload...)
+		default:
+			self.closeWith(CloseProtocolError, "unknown opcode")
+			return 0, nil, ErrProtocol
+		}
+		if self.opts.MaxMessage > 0 && int64(len(msg)) > self.opts.MaxMessage {
+			self.closeWith(CloseTooBig, ErrTooBig.Error())
+			return 0, nil, ErrTooBig
+		}
+		if fin {
+			if op == OpText && !utf8.Valid(msg) {
+				self.closeWith(CloseInvalidData, "invalid utf-8")
+				return 0, nil, ErrProtocol
+			}
+			return op, msg, nil
+		}
+	}
+}
+
+// read a text message
+func (self *Socket) ReadText() (string, error) {
+	_, msg, err := self.ReadMessage()
+	return string(msg), err
+}
+
+// write a complete data message
+func (self *Socket) WriteMessage(op int, data []byte) error {
+	return self.write(op, data)
+}
+
+// write a text message
+func (self *Socket) WriteText(s string) error {
+	return self.write(OpText, []byte(s))
+}
+
+// send a close frame and close the connection
+func (self *Socket) Close() error {
+	self.closeWith(CloseNormal, "")
+	return nil
+}
+
+// return a channel closed when the connection is closed
+func (self *Socket) Done() <-chan struct{} {
+	return self.done
+}
+
+// read a single frame
+func (self *Socket) readFrame() (bool, int, []byte, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(self.br, hdr[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin := hdr[0]&0x80 != 0
+	op := int(hdr[0] & 0x0f)
+	if hdr[0]&0x70 != 0 || hdr[1]&0x80 == 0 {
+		return false, 0, nil, ErrProtocol
+	}
+	n := int64(hdr[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(self.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(self.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = int64(binary.BigEndian.Uint64(ext[:]))
+		if n < 0 {
+			return false, 0, nil, ErrProtocol
+		}
+	}
+	if op >= OpClose && (!fin || n > 125) {
+		return false, 0, nil, ErrProtocol
+	}
+	if self.opts.MaxMessage > 0 && n > self.opts.MaxMessage {
+		return false, 0, nil, ErrTooBig
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(self.br, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(self.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, op, payload, nil
+}
+
+// write a single unmasked frame
+func (self *Socket) write(op int, data []byte) error {
+	self.wmu.Lock()
+	defer self.wmu.Unlock()
+	select {
+	case <-self.done:
+		return ErrClosed
+	default:
+	}
+	frame := make([]byte, 0, len(data)+10)
+	frame = append(frame, 0x80|byte(op))
+	switch n := len(data); {
+	case n <= 125:
+		frame = append(frame, byte(n))
+	case n <= 0xffff:
+		frame = append(frame, 126, byte(n>>8), byte(n))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	frame = append(frame, data...)
+	if self.opts.WriteWait > 0 {
+		self.conn.SetWriteDeadline(time.Now().Add(self.opts.WriteWait))
+	}
+	_, err := self.conn.Write(frame)
+	return err
+}
+
+// send a close frame once and tear down the connection
+func (self *Socket) closeWith(code int, reason string) {
+	self.once.Do(func() {
+		payload := make([]byte, 2, 2+len(reason))
+		binary.BigEndian.PutUint16(payload, uint16(code))
+		self.write(OpClose, append(payload, reason...))
+		self.wmu.Lock()
+		close(self.done)
+		self.wmu.Unlock()
+		self.conn.Close()
+	})
+}
+
+// push the read deadline out by the pong wait
+func (self *Socket) extend() {
+	if self.opts.PongWait > 0 {
+		self.conn.SetReadDeadline(time.Now().Add(self.opts.PongWait))
+	}
+}
+
+// ping the peer until the connection closes
+func (self *Socket) keepalive() {
+	ticker := time.NewTicker(self.opts.PingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := self.write(OpPing, nil); err != nil {
+				self.closeWith(CloseGoingAway, "")
+				return
+			}
+		case <-self.done:
+			return
+		}
+	}
+}
+
+// test whether a comma separated header contains a token
+func headerHas(hdr http.Header, key, token string) bool {
+	for _, t := range headerTokens(hdr, key) {
+		if strings.EqualFold(t, token) {
+			return true
+		}
+	}
+	return false
+}
+
+// split comma separated header values
+func headerTokens(hdr http.Header, key string) []string {
+	var tokens []string
+	for _, v := range hdr[http.CanonicalHeaderKey(key)] {
+		for _, t := range strings.Split(v, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				tokens = append(tokens, t)
+			}
+		}
+	}
+	return tokens
+}
+
+// default origin check, allow missing or same host origins
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// websocket broadcast hub, groups connections by key (ie. session id)
+type Hub struct {
+	groups map[string]map[*Socket]bool
+	mu     sync.RWMutex
+}
+
+// return a new hub instance
+func NewHub() *Hub {
+	return &Hub{
+		groups: make(map[string]map[*Socket]bool),
+	}
+}
+
+// add a connection to a group
+func (self *Hub) Join(key string, ws *Socket) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if self.groups[key] == nil {
+		self.groups[key] = make(map[*Socket]bool)
+	}
+	self.groups[key][ws] = true
+}
+
+// remove a connection from a group
+func (self *Hub) Leave(key string, ws *Socket) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	delete(self.groups[key], ws)
+	if len(self.groups[key]) == 0 {
+		delete(self.groups, key)
+	}
+}
+
+// return the number of connections in a group
+func (self *Hub) Len(key string) int {
+	self.mu.RLock()
+	defer self.mu.RUnlock()
+	return len(self.groups[key])
+}
+
+// send a message to every connection in a group, dropping failed ones
+func (self *Hub) Broadcast(key string, op int, data []byte) {
+	self.mu.RLock()
+	conns := make([]*Socket, 0, len(self.groups[key]))
+	for ws := range self.groups[key] {
+		conns = append(conns, ws)
+	}
+	self.mu.RUnlock()
+	for _, ws := range conns {
+		if err := ws.WriteMessage(op, data); err != nil {
+			self.Leave(key, ws)
+			ws.Close()
+		}
+	}
+}
+
+// send a message to every connection in every group
+func (self *Hub) BroadcastAll(op int, data []byte) {
+	self.mu.RLock()
+	keys := make([]string, 0, len(self.groups))
+	for key := range self.groups {
+		keys = append(keys, key)
+	}
+	self.mu.RUnlock()
+	for _, key := range keys {
+		self.Broadcast(key, op, data)
+	}
+}
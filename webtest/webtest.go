@@ -0,0 +1,384 @@
+// ----------
+// webtest.go ::: in-process test client for multiplexer applications
+// ----------
+// Copyright (c) 2013-Present, Scott Cagno. All rights reserved.
+// This source code is governed by a BSD-style license.
+
+package webtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/scottcagno/net_kit/csrf"
+	"github.com/scottcagno/net_kit/web"
+)
+
+// default base url requests are made against, https so the jar keeps
+// secure cookies
+const BASE = "https://example.com"
+
+// test client, keeps cookies and the last seen csrf token between requests
+type Client struct {
+	T       testing.TB
+	Handler http.Handler
+	Jar     http.CookieJar
+	Token   string
+	base    *url.URL
+}
+
+// return a new test client driving handler (usually a multiplexer)
+func New(t testing.TB, handler http.Handler) *Client {
+	jar, _ := cookiejar.New(nil)
+	base, _ := url.Parse(BASE)
+	return &Client{
+		T:       t,
+		Handler: handler,
+		Jar:     jar,
+		base:    base,
+	}
+}
+
+// make requests against another base url, ie. "http://localhost"
+func (self *Client) Base(rawurl string) *Client {
+	base, err := url.Parse(rawurl)
+	if err != nil {
+		self.T.Fatalf("webtest: invalid base url: %v", err)
+	}
+	self.base = base
+	return self
+}
+
+// start a get request
+func (self *Client) Get(path string) *Request {
+	return self.NewRequest("GET", path)
+}
+
+// start a post request
+func (self *Client) Post(path string) *Request {
+	return self.NewRequest("POST", path)
+}
+
+// start a put request
+func (self *Client) Put(path string) *Request {
+	return self.NewRequest("PUT", path)
+}
+
+// start a delete request
+func (self *Client) Delete(path string) *Request {
+	return self.NewRequest("DELETE", path)
+}
+
+// start a request with any method
+func (self *Client) NewRequest(method, path string) *Request {
+	return &Request{
+		client: self,
+		method: method,
+		path:   path,
+		header: make(http.Header),
+	}
+}
+
+// post a form, including the last csrf token seen
+func (self *Client) Submit(path string, form url.Values) *Response {
+	req := self.Post(path).CSRF()
+	for k, vals := range form {
+		for _, v := range vals {
+			req.Form(k, v)
+		}
+	}
+	return req.Do()
+}
+
+// return the value of a cookie held in the jar
+func (self *Client) Cookie(name string) string {
+	for _, c := range self.Jar.Cookies(self.base) {
+		if c.Name == name {
+			return c.Value
+		}
+	}
+	return ""
+}
+
+// request under construction
+type Request struct {
+	client *Client
+	method string
+	path   string
+	header http.Header
+	form   url.Values
+	body   io.Reader
+}
+
+// set a request header
+func (self *Request) Header(key, val string) *Request {
+	self.header.Set(key, val)
+	return self
+}
+
+// add an urlencoded form value
+func (self *Request) Form(key, val string) *Request {
+	if self.form == nil {
+		self.form = make(url.Values)
+	}
+	self.form.Add(key, val)
+	return self
+}
+
+// send v as a json body
+func (self *Request) JSON(v interface{}) *Request {
+	b, err := json.Marshal(v)
+	if err != nil {
+		self.client.T.Fatalf("webtest: encoding json body: %v", err)
+	}
+	self.body = bytes.NewReader(b)
+	self.header.Set("Content-Type", "application/json")
+	return self
+}
+
+// send a raw body with the given content type
+func (self *Request) Body(typ string, body io.Reader) *Request {
+	self.body = body
+	self.header.Set("Content-Type", typ)
+	return self
+}
+
+// include the last csrf token seen as a header
+func (self *Request) CSRF() *Request {
+	if self.client.Token == "" {
+		self.client.T.Errorf("webtest: no csrf token has been seen yet")
+	}
+	self.header.Set(csrf.HEADER, self.client.Token)
+	return self
+}
+
+// serve the request and return the recorded response
+func (self *Request) Do() *Response {
+	self.client.T.Helper()
+	body := self.body
+	if self.form != nil && body == nil {
+		body = strings.NewReader(self.form.Encode())
+		self.header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	req := httptest.NewRequest(self.method, self.url(), body)
+	for k, v := range self.header {
+		req.Header[k] = v
+	}
+	for _, c := range self.client.Jar.Cookies(self.client.base) {
+		req.AddCookie(c)
+	}
+	req, route := web.RecordRoute(req)
+	rec := httptest.NewRecorder()
+	self.client.Handler.ServeHTTP(rec, req)
+	self.client.Jar.SetCookies(self.client.base, rec.Result().Cookies())
+	resp := &Response{
+		ResponseRecorder: rec,
+		Request:          req,
+		Pattern:          route(),
+		client:           self.client,
+	}
+	if token := resp.csrfToken(); token != "" {
+		self.client.Token = token
+	}
+	return resp
+}
+
+// request path resolved against the client base url
+func (self *Request) url() string {
+	ref, err := url.Parse(self.path)
+	if err != nil {
+		self.client.T.Fatalf("webtest: invalid path %q: %v", self.path, err)
+	}
+	return self.client.base.ResolveReference(ref).String()
+}
+
+// recorded response with fluent assertions
+type Response struct {
+	*httptest.ResponseRecorder
+	Request *http.Request
+	Pattern string
+	client  *Client
+}
+
+// assert the status code
+func (self *Response) Status(code int) *Response {
+	self.client.T.Helper()
+	if self.Code != code {
+		self.client.T.Errorf("%s %s: expected status %d, got %d", self.Request.Method, self.Request.URL.Path, code, self.Code)
+	}
+	return self
+}
+
+// assert the registered route pattern that served the request
+func (self *Response) Route(pattern string) *Response {
+	self.client.T.Helper()
+	if self.Pattern != pattern {
+		self.client.T.Errorf("%s %s: expected route %q, got %q", self.Request.Method, self.Request.URL.Path, pattern, self.Pattern)
+	}
+	return self
+}
+
+// assert a response header value
+func (self *Response) HasHeader(key, val string) *Response {
+	self.client.T.Helper()
+	if got := self.Header().Get(key); got != val {
+		self.client.T.Errorf("%s %s: expected header %s %q, got %q", self.Request.Method, self.Request.URL.Path, key, val, got)
+	}
+	return self
+}
+
+// assert a redirect to location
+func (self *Response) Redirects(location string) *Response {
+	self.client.T.Helper()
+	if self.Code < 300 || self.Code > 399 || self.Header().Get("Location") != location {
+		self.client.T.Errorf("%s %s: expected redirect to %q, got %d %q", self.Request.Method, self.Request.URL.Path,
+			location, self.Code, self.Header().Get("Location"))
+	}
+	return self
+}
+
+// follow a redirect with a get request
+func (self *Response) Follow() *Response {
+	self.client.T.Helper()
+	location := self.Header().Get("Location")
+	if location == "" {
+		self.client.T.Fatalf("%s %s: response is not a redirect", self.Request.Method, self.Request.URL.Path)
+	}
+	return self.client.Get(location).Do()
+}
+
+// assert the body contains s
+func (self *Response) Contains(s string) *Response {
+	self.client.T.Helper()
+	if !strings.Contains(self.Body.String(), s) {
+		self.client.T.Errorf("%s %s: expected body to contain %q, got:\n%s", self.Request.Method, self.Request.URL.Path, s, self.Body.String())
+	}
+	return self
+}
+
+// decode a json body into v
+func (self *Response) DecodeJSON(v interface{}) *Response {
+	self.client.T.Helper()
+	if err := json.Unmarshal(self.Body.Bytes(), v); err != nil {
+		self.client.T.Errorf("%s %s: decoding json body: %v", self.Request.Method, self.Request.URL.Path, err)
+	}
+	return self
+}
+
+// assert the json body is semantically equal to expected
+func (self *Response) JSONEq(expected string) *Response {
+	self.client.T.Helper()
+	var want, got interface{}
+	if err := json.Unmarshal([]byte(expected), &want); err != nil {
+		self.client.T.Fatalf("webtest: invalid expected json: %v", err)
+	}
+	if err := json.Unmarshal(self.Body.Bytes(), &got); err != nil || !reflect.DeepEqual(want, got) {
+		self.client.T.Errorf("%s %s: expected json %s, got %s", self.Request.Method, self.Request.URL.Path, expected, self.Body.String())
+	}
+	return self
+}
+
+// assert the html body has an element with the given tag and attribute
+// name value pairs, ie. HasElement("input", "name", "email")
+func (self *Response) HasElement(tag string, attrs ...string) *Response {
+	self.client.T.Helper()
+	if self.findElement(tag, attrs...) == nil {
+		self.client.T.Errorf("%s %s: expected html element <%s %v>", self.Request.Method, self.Request.URL.Path, tag, attrs)
+	}
+	return self
+}
+
+// assert the html title
+func (self *Response) Title(title string) *Response {
+	self.client.T.Helper()
+	if got := self.text("title"); got != title {
+		self.client.T.Errorf("%s %s: expected title %q, got %q", self.Request.Method, self.Request.URL.Path, title, got)
+	}
+	return self
+}
+
+// return the attributes of the first matching html element, or nil
+func (self *Response) findElement(tag string, attrs ...string) map[string]string {
+	dec := htmlDecoder(self.Body.Bytes())
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil
+		}
+		el, ok := tok.(xml.StartElement)
+		if !ok || !strings.EqualFold(el.Name.Local, tag) {
+			continue
+		}
+		found := make(map[string]string, len(el.Attr))
+		for _, a := range el.Attr {
+			found[strings.ToLower(a.Name.Local)] = a.Value
+		}
+		match := true
+		for i := 0; i+1 < len(attrs); i += 2 {
+			if v, ok := found[strings.ToLower(attrs[i])]; !ok || v != attrs[i+1] {
+				match = false
+			}
+		}
+		if match {
+			return found
+		}
+	}
+}
+
+// return the text content of the first element with the given tag
+func (self *Response) text(tag string) string {
+	dec := htmlDecoder(self.Body.Bytes())
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return ""
+		}
+		if el, ok := tok.(xml.StartElement); ok && strings.EqualFold(el.Name.Local, tag) {
+			var buf strings.Builder
+			for {
+				tok, err := dec.Token()
+				if err != nil {
+					return buf.String()
+				}
+				switch t := tok.(type) {
+				case xml.CharData:
+					buf.Write(t)
+				case xml.EndElement:
+					return strings.TrimSpace(buf.String())
+				}
+			}
+		}
+	}
+}
+
+// extract a csrf token from a hidden input or meta tag
+func (self *Response) csrfToken() string {
+	if !strings.Contains(self.Header().Get("Content-Type"), "html") {
+		return ""
+	}
+	if el := self.findElement("input", "name", csrf.FIELD); el != nil {
+		return el["value"]
+	}
+	if el := self.findElement("meta", "name", "csrf-token"); el != nil {
+		return el["content"]
+	}
+	return ""
+}
+
+// lenient xml decoder for html documents
+func htmlDecoder(b []byte) *xml.Decoder {
+	dec := xml.NewDecoder(bytes.NewReader(b))
+	dec.Strict = false
+	dec.AutoClose = xml.HTMLAutoClose
+	dec.Entity = xml.HTMLEntity
+	return dec
+}
@@ -0,0 +1,97 @@
+// ---------------
+// webtest_test.go ::: test client against a session and csrf protected app
+// ---------------
+// Copyright (c) 2013-Present, Scott Cagno. All rights reserved.
+// This source code is governed by a BSD-style license.
+
+package webtest
+
+import (
+	"bytes"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/scottcagno/net_kit/csrf"
+	"github.com/scottcagno/net_kit/sess"
+	"github.com/scottcagno/net_kit/web"
+)
+
+// small app with a csrf protected form, an upload and session counters
+func newApp(t *testing.T, store *sess.Store) *Client {
+	opts := web.DefaultUploadOptions()
+	opts.Dir = t.TempDir()
+	uploader := web.NewUploader(opts)
+	mux := web.NewMultiplexer()
+	mux.Get("/form", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprintf(w, `<html><head><title>Form</title></head><body><form method="post">%s</form></body></html>`, csrf.Field(r))
+	})
+	mux.Post("/form", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "saved ", r.PostFormValue("name"))
+	})
+	mux.Post("/upload", func(w http.ResponseWriter, r *http.Request) {
+		uploads, _, err := uploader.Save(w, r)
+		if err != nil {
+			http.Error(w, err.Error(), web.UploadStatus(err))
+			return
+		}
+		fmt.Fprint(w, len(uploads), " ", uploads[0].ContentType)
+	})
+	mux.Get("/count", func(w http.ResponseWriter, r *http.Request) {
+		session := store.GetSession(w, r)
+		n := session.GetInt("count") + 1
+		session.SetValue("count", n)
+		fmt.Fprint(w, n)
+	})
+	return New(t, web.MethodOverride(csrf.NewGuard(store).Protect(mux)))
+}
+
+// post a multipart upload with the csrf token as its first field
+func upload(c *Client, name, content string) *Response {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField(csrf.FIELD, c.Token)
+	fw, _ := mw.CreateFormFile("file", name)
+	fw.Write([]byte(content))
+	mw.Close()
+	return c.Post("/upload").Body(mw.FormDataContentType(), &body).Do()
+}
+
+func TestSessionCookie(t *testing.T) {
+	store := sess.NewSessionStore("sid", sess.HOUR)
+	defer store.Stop()
+	store.Cookie.Secure = true
+	c := newApp(t, store)
+	c.Get("/count").Do().Status(200).Route("/count").Contains("1")
+	if c.Cookie("sid") == "" {
+		t.Fatal("secure session cookie was not kept")
+	}
+	c.Get("/count").Do().Contains("2")
+}
+
+func TestCSRF(t *testing.T) {
+	store := sess.NewSessionStore("sid", sess.HOUR)
+	defer store.Stop()
+	c := newApp(t, store)
+	c.Get("/form").Do().Status(200).Title("Form").HasElement("input", "name", csrf.FIELD)
+	if c.Token == "" {
+		t.Fatal("csrf token was not picked up")
+	}
+	c.Submit("/form", url.Values{"name": {"bob"}}).Status(200).Contains("saved bob")
+	c.Post("/form").Form("name", "eve").Do().Status(403)
+	c.Post("/form").Form("name", "eve").Form(csrf.FIELD, "forged").Do().Status(403)
+}
+
+func TestUpload(t *testing.T) {
+	store := sess.NewSessionStore("sid", sess.HOUR)
+	defer store.Stop()
+	c := newApp(t, store)
+	c.Get("/form").Do()
+	upload(c, "notes.txt", "hello").Status(200).Contains("1 text/plain")
+	upload(c, "page.html", "<html><script>alert(1)</script></html>").Status(415)
+	c.Token = "forged"
+	upload(c, "notes.txt", "hello").Status(403)
+}